@@ -0,0 +1,141 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/chenglch/golang-xcat3client/utils"
+	"github.com/spf13/cobra"
+)
+
+type ConsoleOptions struct {
+	url string
+	log string
+}
+
+var consoleOpts *ConsoleOptions
+
+// _console_input copies the input of the user to the console until the
+// escape sequence ~. is typed at the beginning of a line. ~~ sends a single ~.
+func _console_input(in io.Reader, console io.Writer) error {
+	buf := make([]byte, 1024)
+	lineStart, tilde := true, false
+	for {
+		n, err := in.Read(buf)
+		if err != nil {
+			return err
+		}
+		out := make([]byte, 0, n+1)
+		for _, b := range buf[:n] {
+			if tilde {
+				tilde = false
+				if b == '.' {
+					_, err = console.Write(out)
+					return err
+				}
+				if b != '~' {
+					out = append(out, '~')
+				}
+			} else if lineStart && b == '~' {
+				tilde = true
+				continue
+			}
+			out = append(out, b)
+			lineStart = b == '\r' || b == '\n'
+		}
+		if _, err = console.Write(out); err != nil {
+			return err
+		}
+	}
+}
+
+func Console(cmd *cobra.Command, args []string) {
+	if len(args) != 1 {
+		fmt.Println("console command should accept one node as the argument.")
+		os.Exit(1)
+	}
+	consoleUrl := consoleOpts.url
+	if consoleUrl == "" {
+		names, err := _expand_noderange(args[0])
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		if len(names) != 1 {
+			fmt.Printf("Node range %s holds %d nodes, the console is opened for one node.\n", args[0], len(names))
+			os.Exit(1)
+		}
+		client, err := NewNodeClient()
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		if consoleUrl, err = client.Console(names[0]); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+	console, err := utils.DialConsole(consoleUrl, nil)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	defer console.Close()
+	var out io.Writer = os.Stdout
+	if consoleOpts.log != "" {
+		logFile, err := os.OpenFile(consoleOpts.log, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		defer logFile.Close()
+		out = io.MultiWriter(os.Stdout, logFile)
+	}
+	if utils.IsTerminal(os.Stdin) {
+		restore, err := utils.MakeRaw()
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		defer restore()
+	}
+	fmt.Printf("[Connected to %s, enter ~. to exit]\r\n", args[0])
+	done := make(chan error, 2)
+	go func() {
+		_, err := io.Copy(out, console)
+		done <- err
+	}()
+	go func() {
+		done <- _console_input(os.Stdin, console)
+	}()
+	err = <-done
+	fmt.Print("\r\n[Disconnected]\r\n")
+	if err != nil && err != io.EOF {
+		fmt.Printf("%s\r\n", err)
+	}
+}
+
+func ConsoleCommand() *cobra.Command {
+	consoleOpts = new(ConsoleOptions)
+	cmd := &cobra.Command{
+		Use:   "console <node> [--log <file>]",
+		Short: "Open the serial console of a node.",
+		Long: `Open the serial console of a node through the console proxy of the server.
+		Format: console <node> [--log <file>]
+		Enter ~. at the beginning of a line to exit and ~~ to send ~. --url connects to a
+		ws://, wss://, telnet:// or tcp:// console directly, like a local echo server
+		started with ncat -lk 2300 -e /bin/cat for console node1 --url tcp://127.0.0.1:2300`,
+		Run: Console,
+	}
+	cmd.Flags().StringVarP(&consoleOpts.url, "url", "", "",
+		`Connect to this console url instead of asking the server.`)
+	cmd.Flags().StringVarP(&consoleOpts.log, "log", "", "",
+		`Append the output of the console to the file.`)
+	_add_selector_flag(cmd)
+	return cmd
+}
+
+func init() {
+	RootCmd.AddCommand(ConsoleCommand())
+}
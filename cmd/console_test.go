@@ -0,0 +1,34 @@
+package cmd
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestConsoleInput(t *testing.T) {
+	cases := []struct {
+		input  string
+		output string
+		err    error
+	}{
+		{"ls\r~.", "ls\r", nil},
+		{"~.ignored", "", nil},
+		{"~~x\r~.", "~x\r", nil},
+		{"~x\n~.", "~x\n", nil},
+		{"a~.b\r", "a~.b\r", io.EOF},
+		{"a\n~", "a\n", io.EOF},
+	}
+	for _, c := range cases {
+		// one byte per read, so that the escape is split across reads
+		for _, in := range []io.Reader{strings.NewReader(c.input), iotest.OneByteReader(strings.NewReader(c.input))} {
+			var out bytes.Buffer
+			err := _console_input(in, &out)
+			if err != c.err || out.String() != c.output {
+				t.Errorf("input %q: got %q, %v, expected %q, %v", c.input, out.String(), err, c.output, c.err)
+			}
+		}
+	}
+}
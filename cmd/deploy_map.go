@@ -0,0 +1,312 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/chenglch/golang-xcat3client/utils"
+)
+
+// DeployTarget is the osimage and the state a node is deployed with.
+type DeployTarget struct {
+	Osimage string `json:"osimage"`
+	State   string `json:"state"`
+}
+
+// DeployMapEntry maps the node(s) of a node range to a deploy target.
+type DeployMapEntry struct {
+	Noderange string
+	Target    DeployTarget
+}
+
+// DEPLOY_MAP_FIELDS are the keys of a deploy map entry which is not only an
+// osimage.
+var DEPLOY_MAP_FIELDS = []string{"osimage", "state"}
+
+// _parse_deploy_map parses a deploy map, mapping node ranges to an osimage,
+// or to the osimage and the state. It is either a JSON object like
+// {"compute[01-40]": "rhels7.3", "gpu": {"osimage": "ubuntu16.04", "state": "dhcp"}}
+// or the same in YAML, see _parse_deploy_yaml.
+func _parse_deploy_map(content []byte) ([]*DeployMapEntry, error) {
+	var entries []*DeployMapEntry
+	var err error
+	if trimmed := bytes.TrimSpace(content); len(trimmed) > 0 && trimmed[0] == '{' {
+		entries, err = _parse_deploy_json(content)
+	} else {
+		entries, err = _parse_deploy_yaml(content)
+	}
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Noderange < entries[j].Noderange
+	})
+	return entries, nil
+}
+
+func _parse_deploy_json(content []byte) ([]*DeployMapEntry, error) {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(content, &raw); err != nil {
+		return nil, fmt.Errorf("Could not parse the deploy map, expected a JSON object: %s", err)
+	}
+	entries := make([]*DeployMapEntry, 0, len(raw))
+	for noderange, value := range raw {
+		entry := &DeployMapEntry{Noderange: noderange}
+		if err := json.Unmarshal(value, &entry.Target.Osimage); err != nil {
+			decoder := json.NewDecoder(bytes.NewReader(value))
+			decoder.DisallowUnknownFields()
+			if err = decoder.Decode(&entry.Target); err != nil {
+				return nil, fmt.Errorf("Invalid deploy map entry %s: %s", noderange, err)
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// _parse_deploy_yaml parses a deploy map in the block style of YAML, each
+// node range followed by its osimage, or by the osimage and the state on
+// the indented lines below, like
+//
+//	compute[01-40]: rhels7.3
+//	gpu:
+//	  osimage: ubuntu16.04
+//	  state: dhcp
+//
+// Keys and values may be quoted and # starts a comment. The other YAML
+// constructs, like flow collections or anchors, are rejected.
+func _parse_deploy_yaml(content []byte) ([]*DeployMapEntry, error) {
+	entries := make([]*DeployMapEntry, 0)
+	seen := make(map[string]bool)
+	var nested *DeployMapEntry
+	indent := -1
+	for i, line := range strings.Split(string(content), "\n") {
+		lineno := i + 1
+		line = strings.TrimRight(line, " \t\r")
+		text := strings.TrimLeft(line, " ")
+		if text == "" || text[0] == '#' || (lineno == 1 && text == "---") {
+			continue
+		}
+		if strings.HasPrefix(text, "\t") {
+			return nil, fmt.Errorf("Deploy map line %d: tabs are not allowed for indentation.", lineno)
+		}
+		depth := len(line) - len(text)
+		key, value, err := _yaml_pair(text)
+		if err != nil {
+			return nil, fmt.Errorf("Deploy map line %d: %s", lineno, err)
+		}
+		if depth > 0 {
+			if nested == nil || (indent >= 0 && depth != indent) {
+				return nil, fmt.Errorf("Deploy map line %d: unexpected indentation.", lineno)
+			}
+			indent = depth
+			switch key {
+			case "osimage":
+				nested.Target.Osimage = value
+			case "state":
+				nested.Target.State = value
+			default:
+				return nil, fmt.Errorf("Deploy map line %d: unknown key %s, expected %s.", lineno, key,
+					strings.Join(DEPLOY_MAP_FIELDS, " or "))
+			}
+			continue
+		}
+		if seen[key] {
+			return nil, fmt.Errorf("Deploy map line %d: duplicate node range %s.", lineno, key)
+		}
+		seen[key] = true
+		entry := &DeployMapEntry{Noderange: key, Target: DeployTarget{Osimage: value}}
+		entries = append(entries, entry)
+		nested, indent = nil, -1
+		if value == "" {
+			nested = entry
+		}
+	}
+	return entries, nil
+}
+
+// _yaml_pair splits a 'key: value' line, removing the quotes and the trailing
+// comment. A missing or null value gives "".
+func _yaml_pair(text string) (string, string, error) {
+	key, rest, err := _yaml_scalar(text, true)
+	if err != nil {
+		return "", "", err
+	}
+	if !strings.HasPrefix(rest, ":") || (len(rest) > 1 && rest[1] != ' ') {
+		return "", "", fmt.Errorf("expected 'key: value'")
+	}
+	rest = strings.TrimLeft(rest[1:], " ")
+	if rest == "" || rest[0] == '#' {
+		return key, "", nil
+	}
+	value, rest, err := _yaml_scalar(rest, false)
+	if err != nil {
+		return "", "", err
+	}
+	if rest = strings.TrimLeft(rest, " "); rest != "" && rest[0] != '#' {
+		return "", "", fmt.Errorf("unexpected %s after the value", rest)
+	}
+	if value == "~" || value == "null" {
+		value = ""
+	}
+	return key, value, nil
+}
+
+// _yaml_scalar reads a quoted or plain scalar at the start of text and returns
+// it with the text left after it. A plain key stops at ': ' or a final ':',
+// a plain value at ' #'.
+func _yaml_scalar(text string, isKey bool) (string, string, error) {
+	switch text[0] {
+	case '"':
+		for i := 1; i < len(text); i++ {
+			if text[i] == '\\' {
+				i++
+			} else if text[i] == '"' {
+				value, err := strconv.Unquote(text[:i+1])
+				if err != nil {
+					return "", "", fmt.Errorf("invalid quoted string %s", text[:i+1])
+				}
+				return value, text[i+1:], nil
+			}
+		}
+		return "", "", fmt.Errorf("unterminated quote")
+	case '\'':
+		var out strings.Builder
+		for i := 1; i < len(text); i++ {
+			if text[i] != '\'' {
+				out.WriteByte(text[i])
+			} else if i+1 < len(text) && text[i+1] == '\'' {
+				out.WriteByte('\'')
+				i++
+			} else {
+				return out.String(), text[i+1:], nil
+			}
+		}
+		return "", "", fmt.Errorf("unterminated quote")
+	case '{', '[', '|', '>', '&', '*', '!', '-', '?', '%', '@', '`':
+		// node ranges like [1-4]n need quotes in YAML too
+		if text[0] != '-' || len(text) == 1 || text[1] == ' ' {
+			return "", "", fmt.Errorf("unsupported YAML syntax at %s, please quote the text", text)
+		}
+	}
+	end := len(text)
+	if isKey {
+		if i := strings.Index(text, ": "); i >= 0 {
+			end = i
+		} else if strings.HasSuffix(text, ":") {
+			end = len(text) - 1
+		}
+	} else if i := strings.Index(text, " #"); i >= 0 {
+		end = i
+	}
+	return strings.TrimRight(text[:end], " "), text[end:], nil
+}
+
+// _deploy_plan returns the deploy target of each node. The target comes from
+// the deploy map, then from --osimage, then from the node attribute given by
+// --attr, and the state from --state when the map does not set it. Without a
+// node range the node(s) of the deploy map are deployed.
+func _deploy_plan(noderange string) ([]string, map[string]DeployTarget, error) {
+	plan := make(map[string]DeployTarget)
+	if deployOpts.mapFile != "" {
+		content, err := ioutil.ReadFile(deployOpts.mapFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		entries, err := _parse_deploy_map(content)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, entry := range entries {
+			if entry.Target.Osimage == "" && !deployOpts.delete {
+				return nil, nil, fmt.Errorf("No osimage for %s in the deploy map.", entry.Noderange)
+			}
+			names, err := _expand_noderange(entry.Noderange)
+			if err != nil {
+				return nil, nil, err
+			}
+			for _, name := range names {
+				if prev, ok := plan[name]; ok && prev != entry.Target {
+					return nil, nil, fmt.Errorf("Node %s is mapped to both %s and %s.", name, prev.Osimage, entry.Target.Osimage)
+				}
+				plan[name] = entry.Target
+			}
+		}
+	}
+	var names []string
+	if noderange != "" {
+		var err error
+		if names, err = _expand_noderange(noderange); err != nil {
+			return nil, nil, err
+		}
+	} else {
+		names = make([]string, 0, len(plan))
+		for name := range plan {
+			names = append(names, name)
+		}
+		utils.SortNatural(names)
+	}
+	attrs := make(map[string]string)
+	if deployOpts.osimage == "" && deployOpts.attr != "" && !deployOpts.delete {
+		unmapped := make([]string, 0, len(names))
+		for _, name := range names {
+			if _, ok := plan[name]; !ok {
+				unmapped = append(unmapped, name)
+			}
+		}
+		if len(unmapped) > 0 {
+			client, err := NewNodeClient()
+			if err != nil {
+				return nil, nil, err
+			}
+			path := strings.Split(deployOpts.attr, ".")
+			nodes, err := _show_nodes(client, unmapped, []string{path[0]})
+			if err != nil {
+				return nil, nil, err
+			}
+			for _, node := range nodes {
+				if value, ok := utils.Lookup(node, path); ok && value != nil && value != "" {
+					attrs[node["name"].(string)] = fmt.Sprint(value)
+				}
+			}
+		}
+	}
+	ret := make(map[string]DeployTarget, len(names))
+	for _, name := range names {
+		target, ok := plan[name]
+		if !ok {
+			target.Osimage = deployOpts.osimage
+		}
+		if target.Osimage == "" {
+			target.Osimage = attrs[name]
+		}
+		if target.State == "" {
+			target.State = deployOpts.state
+		}
+		if target.Osimage == "" && !deployOpts.delete && deployOpts.mapFile != "" {
+			return nil, nil, fmt.Errorf("No osimage for node %s.", name)
+		}
+		ret[name] = target
+	}
+	return names, ret, nil
+}
+
+// _group_by_target splits the nodes by deploy target, in the order of the
+// first node of each target.
+func _group_by_target(names []string, plan map[string]DeployTarget) ([]DeployTarget, map[DeployTarget][]string) {
+	targets := make([]DeployTarget, 0)
+	groups := make(map[DeployTarget][]string)
+	for _, name := range names {
+		target := plan[name]
+		if _, ok := groups[target]; !ok {
+			targets = append(targets, target)
+		}
+		groups[target] = append(groups[target], name)
+	}
+	return targets, groups
+}
@@ -0,0 +1,59 @@
+package cmd
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseDeployMap(t *testing.T) {
+	expected := []*DeployMapEntry{
+		{Noderange: "compute[01-40]", Target: DeployTarget{Osimage: "rhels7.3"}},
+		{Noderange: "gpu", Target: DeployTarget{Osimage: "ubuntu16.04", State: "dhcp"}},
+		{Noderange: "login,-login2", Target: DeployTarget{Osimage: "rhels7.3 #1"}},
+	}
+	cases := []string{
+		`{"compute[01-40]": "rhels7.3", "gpu": {"osimage": "ubuntu16.04", "state": "dhcp"},
+		"login,-login2": "rhels7.3 #1"}`,
+		`---
+# racks
+compute[01-40]: rhels7.3  # the default image
+gpu:
+  osimage: ubuntu16.04
+  state: 'dhcp'
+"login,-login2": "rhels7.3 #1"
+`,
+	}
+	for _, c := range cases {
+		entries, err := _parse_deploy_map([]byte(c))
+		if err != nil {
+			t.Errorf("%s: %s", c, err)
+			continue
+		}
+		if !reflect.DeepEqual(entries, expected) {
+			t.Errorf("%s: got %v, expected %v", c, entries, expected)
+		}
+	}
+}
+
+func TestParseDeployMapErrors(t *testing.T) {
+	cases := []struct {
+		content string
+		err     string
+	}{
+		{`{"gpu": {"osimage": "a", "stat": "dhcp"}}`, "unknown field"},
+		{"gpu:\n  image: a\n", "unknown key image"},
+		{"gpu: a\n  osimage: b\n", "unexpected indentation"},
+		{"gpu:\n  osimage: a\n    state: dhcp\n", "unexpected indentation"},
+		{"gpu: a\ngpu: b\n", "duplicate node range"},
+		{"gpu: [a, b]\n", "unsupported YAML syntax"},
+		{"- gpu\n", "unsupported YAML syntax"},
+		{"gpu rhels7.3\n", "expected 'key: value'"},
+		{"gpu: 'a\n", "unterminated quote"},
+	}
+	for _, c := range cases {
+		if _, err := _parse_deploy_map([]byte(c.content)); err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Errorf("%q: got %v, expected an error with %q", c.content, err, c.err)
+		}
+	}
+}
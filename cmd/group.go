@@ -0,0 +1,436 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/chenglch/golang-xcat3client/utils"
+	"github.com/spf13/cobra"
+)
+
+// Groups are stored in the comma separated groups attribute of the nodes, so
+// a group exists as long as one node belongs to it.
+
+func _split_groups(value interface{}) []string {
+	groups := make([]string, 0)
+	s, _ := value.(string)
+	for _, group := range strings.Split(s, ",") {
+		if group = strings.TrimSpace(group); group != "" {
+			groups = append(groups, group)
+		}
+	}
+	return groups
+}
+
+// _group_nodes fetches the groups of the nodes from the server and returns
+// the groups of each node.
+func _group_nodes(client *NodeClient, wanted map[string]bool) (map[string][]string, error) {
+	nodes, err := _node_attrs(client, []string{"groups"}, nil, wanted)
+	if err != nil {
+		return nil, err
+	}
+	ret := make(map[string][]string, len(nodes))
+	for _, node := range nodes {
+		ret[node["name"].(string)] = _split_groups(node["groups"])
+	}
+	return ret, nil
+}
+
+// _group_table returns the members of each group.
+func _group_table(nodes map[string][]string) map[string][]string {
+	table := make(map[string][]string)
+	for name, groups := range nodes {
+		for _, group := range groups {
+			table[group] = append(table[group], name)
+		}
+	}
+	for _, members := range table {
+		utils.SortNatural(members)
+	}
+	return table
+}
+
+func _fetch_group_table() (map[string][]string, error) {
+	client, err := NewNodeClient()
+	if err != nil {
+		return nil, err
+	}
+	nodes, err := _group_nodes(client, nil)
+	if err != nil {
+		return nil, err
+	}
+	return _group_table(nodes), nil
+}
+
+// noderangeNodes caches the groups of every node once a node range needed
+// them, for the rest of the command.
+var noderangeNodes map[string][]string
+
+// _resolve_items returns the nodes of each node range item which is not a
+// range: the members of the group with this name, every node for 'all', or
+// else the node with this name. The groups of every node are fetched with
+// one request, whatever the number of items.
+func _resolve_items(items []string) (map[string][]string, error) {
+	if noderangeNodes == nil {
+		client, err := NewNodeClient()
+		if err != nil {
+			return nil, err
+		}
+		if noderangeNodes, err = _group_nodes(client, nil); err != nil {
+			return nil, err
+		}
+	}
+	table := _group_table(noderangeNodes)
+	ret := make(map[string][]string, len(items))
+	for _, item := range items {
+		if members, ok := table[item]; ok {
+			ret[item] = members
+		} else if item == "all" {
+			names := make([]string, 0, len(noderangeNodes))
+			for name := range noderangeNodes {
+				names = append(names, name)
+			}
+			utils.SortNatural(names)
+			ret[item] = names
+		} else {
+			ret[item] = []string{item}
+		}
+	}
+	return ret, nil
+}
+
+// _expand_noderange returns the node names of a node range like
+// node[1-10],compute,-login. An item is a node name, a range or a group
+// name, and the items prefixed with '-' are excluded. 'all' stands for every
+// node unless a group has this name. The groups are read from the server,
+// so that all the admins see the same members.
+func _expand_noderange(noderange string) ([]string, error) {
+	items := make([]string, 0)
+	plain := make([]string, 0)
+	for _, item := range strings.Split(noderange, ",") {
+		if name := strings.TrimPrefix(item, "-"); name != "" {
+			items = append(items, item)
+			if !strings.ContainsAny(name, "[]") {
+				plain = append(plain, name)
+			}
+		}
+	}
+	var resolved map[string][]string
+	if len(plain) > 0 {
+		var err error
+		if resolved, err = _resolve_items(plain); err != nil {
+			return nil, err
+		}
+	}
+	include := make([]string, 0)
+	exclude := make(map[string]bool)
+	for _, item := range items {
+		excluded := strings.HasPrefix(item, "-")
+		item = strings.TrimPrefix(item, "-")
+		names, ok := resolved[item]
+		if !ok {
+			var err error
+			if names, err = utils.ToNodeArray(item); err != nil {
+				return nil, err
+			}
+		}
+		for _, name := range names {
+			if excluded {
+				exclude[name] = true
+			} else {
+				include = append(include, name)
+			}
+		}
+	}
+	names := make([]string, 0, len(include))
+	for _, name := range utils.RmDuplicate(include) {
+		if !exclude[name] {
+			names = append(names, name)
+		}
+	}
+	names, err := _select_nodes(names)
+	if err != nil {
+		return nil, err
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("Node range %s does not hold any node.", noderange)
+	}
+	return names, nil
+}
+
+// _patch_groups stores the new groups of the nodes, with one request for
+// each distinct value.
+func _patch_groups(client *NodeClient, changes map[string][]string) (map[string]interface{}, error) {
+	buckets := make(map[string][]interface{})
+	for name, groups := range changes {
+		value := strings.Join(groups, ",")
+		buckets[value] = append(buckets[value], map[string]string{"name": name})
+	}
+	result := map[string]interface{}{"nodes": make(map[string]interface{})}
+	for value, nodes := range buckets {
+		patch := map[string]interface{}{"op": "add", "path": "/groups", "value": value}
+		if value == "" {
+			patch = map[string]interface{}{"op": "remove", "path": "/groups"}
+		}
+		data := map[string]interface{}{"nodes": nodes, "patches": []interface{}{patch}}
+		ret, err := client.Patch("", data)
+		if err != nil {
+			return nil, err
+		}
+		utils.MergeMap(result["nodes"].(map[string]interface{}), utils.InterfaceToMap(ret["nodes"]))
+	}
+	return result, nil
+}
+
+// _modify_group adds the nodes of the node range to the group, or removes
+// them when add is false.
+func _modify_group(group string, noderange string, add bool) {
+	names, err := _expand_noderange(noderange)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	client, err := NewNodeClient()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	wanted := make(map[string]bool, len(names))
+	for _, name := range names {
+		wanted[name] = true
+	}
+	nodes, err := _group_nodes(client, wanted)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	_update_group_members(client, group, names, nodes, add)
+}
+
+// _update_group_members adds the named nodes to the group, or removes them
+// when add is false. nodes holds the current groups of the nodes.
+func _update_group_members(client *NodeClient, group string, names []string, nodes map[string][]string, add bool) {
+	changes := make(map[string][]string)
+	for _, name := range names {
+		groups, ok := nodes[name]
+		if !ok {
+			fmt.Printf("Could not find node %s\n", name)
+			os.Exit(1)
+		}
+		exist, _ := utils.Contains(groups, group)
+		if add && !exist {
+			changes[name] = append(groups, group)
+		} else if !add && exist {
+			kept := make([]string, 0, len(groups))
+			for _, g := range groups {
+				if g != group {
+					kept = append(kept, g)
+				}
+			}
+			changes[name] = kept
+		}
+	}
+	if len(changes) == 0 {
+		fmt.Println("Nothing to change")
+		return
+	}
+	result, err := _patch_groups(client, changes)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	_print_node_result(result)
+}
+
+func _group_exists(group string) bool {
+	table, err := _fetch_group_table()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	_, ok := table[group]
+	return ok
+}
+
+func CreateGroup(cmd *cobra.Command, args []string) {
+	if len(args) != 2 {
+		fmt.Println("Please specify the group name and the node range.")
+		os.Exit(1)
+	}
+	if _group_exists(args[0]) {
+		fmt.Printf("Group %s already exists\n", args[0])
+		os.Exit(1)
+	}
+	_modify_group(args[0], args[1], true)
+}
+
+func CreateGroupCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "create <group name> <node range>",
+		Short: "Create a group with the node(s).",
+		Long:  `Create a group with the node(s). Format: create <group name> <node range>`,
+		Run:   CreateGroup,
+	}
+	_add_selector_flag(cmd)
+	return cmd
+}
+
+func AddGroup(cmd *cobra.Command, args []string) {
+	if len(args) != 2 {
+		fmt.Println("Please specify the group name and the node range.")
+		os.Exit(1)
+	}
+	if !_group_exists(args[0]) {
+		fmt.Printf("Could not find group %s\n", args[0])
+		os.Exit(1)
+	}
+	_modify_group(args[0], args[1], true)
+}
+
+func AddGroupCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "add <group name> <node range>",
+		Short: "Add node(s) into a group.",
+		Long:  `Add node(s) into a group. Format: add <group name> <node range>`,
+		Run:   AddGroup,
+	}
+	_add_selector_flag(cmd)
+	return cmd
+}
+
+func RemoveGroup(cmd *cobra.Command, args []string) {
+	if len(args) != 2 {
+		fmt.Println("Please specify the group name and the node range.")
+		os.Exit(1)
+	}
+	_modify_group(args[0], args[1], false)
+}
+
+func RemoveGroupCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "remove <group name> <node range>",
+		Short: "Remove node(s) from a group.",
+		Long:  `Remove node(s) from a group. Format: remove <group name> <node range>`,
+		Run:   RemoveGroup,
+	}
+	_add_selector_flag(cmd)
+	return cmd
+}
+
+func DeleteGroup(cmd *cobra.Command, args []string) {
+	if len(args) != 1 {
+		fmt.Println("Please specify the group name.")
+		os.Exit(1)
+	}
+	client, err := NewNodeClient()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	// the members are removed as listed, a member named like a group is not
+	// expanded again
+	nodes, err := _group_nodes(client, nil)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	members, ok := _group_table(nodes)[args[0]]
+	if !ok {
+		fmt.Printf("Could not find group %s\n", args[0])
+		os.Exit(1)
+	}
+	_update_group_members(client, args[0], members, nodes, false)
+}
+
+func DeleteGroupCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "delete <group name>",
+		Short: "Delete a group. The node(s) of the group are kept.",
+		Long:  `Delete a group. The node(s) of the group are kept. Format: delete <group name>`,
+		Run:   DeleteGroup,
+	}
+	return cmd
+}
+
+func ListGroup(cmd *cobra.Command, args []string) {
+	table, err := _fetch_group_table()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if len(table) == 0 {
+		fmt.Println("Could not find any record")
+		os.Exit(1)
+	}
+	groups := make([]string, 0, len(table))
+	for group := range table {
+		groups = append(groups, group)
+	}
+	sort.Strings(groups)
+	for _, group := range groups {
+		fmt.Printf("%s (group) %d (nodes)\n", group, len(table[group]))
+	}
+}
+
+func ListGroupCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "List group(s) in xCAT3 service",
+		Long:  `List group(s) in xCAT3 service. Format: list`,
+		Run:   ListGroup,
+	}
+	return cmd
+}
+
+func ShowGroup(cmd *cobra.Command, args []string) {
+	if len(args) != 1 {
+		fmt.Println("Please specify the group name.")
+		os.Exit(1)
+	}
+	table, err := _fetch_group_table()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	members, ok := table[args[0]]
+	if !ok {
+		fmt.Printf("Could not find group %s\n", args[0])
+		os.Exit(1)
+	}
+	for _, name := range members {
+		fmt.Printf("%s (node)\n", name)
+	}
+}
+
+func ShowGroupCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "show <group name>",
+		Short: "Show the node(s) of a group.",
+		Long:  `Show the node(s) of a group. Format: show <group name>`,
+		Run:   ShowGroup,
+	}
+	return cmd
+}
+
+func GroupCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "group",
+		Short: "This is group child command for xcat3",
+		Long: `xcat3 group --help and xcat3 group help COMMAND to see the usage for specfied
+	command. A group name can be used in any node range, like power compute,-login on`,
+	}
+	return cmd
+}
+
+func init() {
+	GroupCmd := GroupCommand()
+	GroupCmd.AddCommand(ListGroupCommand())
+	GroupCmd.AddCommand(ShowGroupCommand())
+	GroupCmd.AddCommand(CreateGroupCommand())
+	GroupCmd.AddCommand(AddGroupCommand())
+	GroupCmd.AddCommand(RemoveGroupCommand())
+	GroupCmd.AddCommand(DeleteGroupCommand())
+	RootCmd.AddCommand(GroupCmd)
+}
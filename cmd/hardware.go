@@ -0,0 +1,218 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/chenglch/golang-xcat3client/utils"
+	"github.com/spf13/cobra"
+)
+
+type HardwareOptions struct {
+	target string
+	lines  int
+	json   bool
+}
+
+var (
+	inventoryOpts *HardwareOptions
+	vitalsOpts    *HardwareOptions
+	eventlogOpts  *HardwareOptions
+	// HW_PARALLEL_SIZE is the number of nodes above which the hardware
+	// requests are split, as the server talks to the BMC of every node.
+	HW_PARALLEL_SIZE = 200
+)
+
+// _bulk_hardware gets the hardware information of the nodes, split into
+// parallel requests by _parallel_nodes when there are many of them.
+func _bulk_hardware(client *NodeClient, resource string, params url.Values, names []string) (map[string]interface{}, error) {
+	data := client.ToNodesMap(names)
+	if len(names) < HW_PARALLEL_SIZE {
+		return client.Hardware(resource, params, data)
+	}
+	return _parallel_nodes(data, func(part map[string]interface{}, result map[string]interface{}, wg *sync.WaitGroup) {
+		defer wg.Done()
+		ret, err := client.Hardware(resource, params, part)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		utils.MergeMap(result["nodes"].(map[string]interface{}), utils.InterfaceToMap(ret["nodes"]))
+	}), nil
+}
+
+// _flatten_hardware turns the value returned for a node into key/value rows.
+// Nested maps are flattened with dotted keys and list entries are numbered.
+func _flatten_hardware(prefix string, value interface{}, rows [][]string) [][]string {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			key := k
+			if prefix != "" {
+				key = prefix + "." + k
+			}
+			rows = _flatten_hardware(key, v[k], rows)
+		}
+	case []interface{}:
+		for i, item := range v {
+			key := fmt.Sprint(i + 1)
+			if prefix != "" {
+				key = prefix + "." + key
+			}
+			if entry, ok := item.(map[string]interface{}); ok {
+				// an entry like an event is kept on one row
+				pairs := make([]string, 0, len(entry))
+				for k, field := range entry {
+					pairs = append(pairs, fmt.Sprintf("%s=%v", k, field))
+				}
+				sort.Strings(pairs)
+				rows = append(rows, []string{key, strings.Join(pairs, " ")})
+				continue
+			}
+			rows = _flatten_hardware(key, item, rows)
+		}
+	default:
+		if prefix == "" {
+			prefix = "-"
+		}
+		rows = append(rows, []string{prefix, fmt.Sprint(v)})
+	}
+	return rows
+}
+
+func _print_hardware_table(result map[string]interface{}, header string) {
+	nodes := utils.InterfaceToMap(result["nodes"])
+	names := make([]string, 0, len(nodes))
+	for name := range nodes {
+		names = append(names, name)
+	}
+	utils.SortNatural(names)
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, header)
+	for _, name := range names {
+		for _, row := range _flatten_hardware("", nodes[name], nil) {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", name, row[0], row[1])
+		}
+	}
+	w.Flush()
+}
+
+// _hardware runs a hardware command on the node range and prints the result
+// as a table, or as JSON with --json.
+func _hardware(resource string, header string, args []string, opts *HardwareOptions) {
+	if len(args) != 1 {
+		fmt.Printf("%s command should accept node(s) as the argument.\n", resource)
+		os.Exit(1)
+	}
+	names, err := _expand_noderange(args[0])
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	client, err := NewNodeClient()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	params := url.Values{}
+	if opts.target != "" && opts.target != "all" {
+		params.Set("target", opts.target)
+	}
+	if opts.lines > 0 {
+		params.Set("count", fmt.Sprint(opts.lines))
+	}
+	result, err := _bulk_hardware(client, resource, params, names)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if opts.json {
+		out, err := json.Marshal(result)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		utils.PrintJson(out)
+		return
+	}
+	_print_hardware_table(result, header)
+}
+
+func Inventory(cmd *cobra.Command, args []string) {
+	_hardware("inventory", "NODE\tITEM\tVALUE", args, inventoryOpts)
+}
+
+func InventoryCommand() *cobra.Command {
+	inventoryOpts = new(HardwareOptions)
+	cmd := &cobra.Command{
+		Use:   "inventory <node range> [--type <type>] [--json]",
+		Short: "Show the hardware inventory of node(s).",
+		Long: `Show the hardware inventory of node(s), like the model, serial, cpu, memory and
+		firmware. Format: inventory <node range> [--type <type>] [--json]`,
+		Run: Inventory,
+	}
+	cmd.Flags().StringVarP(&inventoryOpts.target, "type", "t", "all",
+		`Only show this part of the inventory, like model, serial, cpu, memory, disk, mac or firmware.`)
+	cmd.Flags().BoolVarP(&inventoryOpts.json, "json", "j", false,
+		`Print the result in json format.`)
+	_add_selector_flag(cmd)
+	return cmd
+}
+
+func Vitals(cmd *cobra.Command, args []string) {
+	_hardware("vitals", "NODE\tSENSOR\tVALUE", args, vitalsOpts)
+}
+
+func VitalsCommand() *cobra.Command {
+	vitalsOpts = new(HardwareOptions)
+	cmd := &cobra.Command{
+		Use:   "vitals <node range> [--type <type>] [--json]",
+		Short: "Show the sensor readings of node(s).",
+		Long: `Show the sensor readings of node(s), like the temperatures, fan speeds, voltages and
+		power draw. Format: vitals <node range> [--type <type>] [--json]`,
+		Run: Vitals,
+	}
+	cmd.Flags().StringVarP(&vitalsOpts.target, "type", "t", "all",
+		`Only show these sensors, like temp, fan, voltage or power.`)
+	cmd.Flags().BoolVarP(&vitalsOpts.json, "json", "j", false,
+		`Print the result in json format.`)
+	_add_selector_flag(cmd)
+	return cmd
+}
+
+func Eventlog(cmd *cobra.Command, args []string) {
+	_hardware("eventlog", "NODE\t#\tEVENT", args, eventlogOpts)
+}
+
+func EventlogCommand() *cobra.Command {
+	eventlogOpts = new(HardwareOptions)
+	cmd := &cobra.Command{
+		Use:   "eventlog <node range> [--lines <n>] [--json]",
+		Short: "Show the BMC event log of node(s).",
+		Long:  `Show the BMC event log of node(s). Format: eventlog <node range> [--lines <n>] [--json]`,
+		Run:   Eventlog,
+	}
+	cmd.Flags().IntVarP(&eventlogOpts.lines, "lines", "n", 10,
+		`Number of the latest events to show, 0 for all.`)
+	cmd.Flags().BoolVarP(&eventlogOpts.json, "json", "j", false,
+		`Print the result in json format.`)
+	_add_selector_flag(cmd)
+	return cmd
+}
+
+func init() {
+	RootCmd.AddCommand(InventoryCommand())
+	RootCmd.AddCommand(VitalsCommand())
+	RootCmd.AddCommand(EventlogCommand())
+}
@@ -0,0 +1,503 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/chenglch/golang-xcat3client/utils"
+	"github.com/spf13/cobra"
+)
+
+type IpamOptions struct {
+	nic     string
+	mac     string
+	dryRun  bool
+	reserve []string
+}
+
+// ipamRange is a range of addresses not to allocate, with the reason.
+type ipamRange struct {
+	first  net.IP
+	last   net.IP
+	reason string
+}
+
+// ipamNetwork is a network with the addresses which are not allocated: the
+// gateway, the dhcp server, the name servers, the dynamic range and the
+// addresses reserved with --reserve.
+type ipamNetwork struct {
+	name     string
+	ipnet    *net.IPNet
+	reserved map[string]string
+	ranges   []ipamRange
+	dynamic  *ipamRange
+}
+
+// ipamAllocation is the address allocated to the nic of a node.
+type ipamAllocation struct {
+	node   string
+	nic    string
+	uuid   string
+	mac    string
+	ip     string
+	action string
+}
+
+var (
+	ipamAllocateOpts *IpamOptions
+	ipamReportOpts   *IpamOptions
+	NIC_LIST_FIELDS  = []string{"uuid", "mac", "name", "ip", "node"}
+)
+
+func _ipam_network(name string, reserve []string) (*ipamNetwork, error) {
+	client, err := NewNetworkClient()
+	if err != nil {
+		return nil, err
+	}
+	result, err := client.Show(name, nil, nil, true)
+	if err != nil {
+		return nil, fmt.Errorf("Could not find network %s: %s", name, err)
+	}
+	var attrs map[string]interface{}
+	if err = json.Unmarshal(result.([]byte), &attrs); err != nil {
+		return nil, err
+	}
+	subnet, _ := attrs["subnet"].(string)
+	netmask, _ := attrs["netmask"].(string)
+	ipnet, err := utils.ParseNetwork(subnet, netmask)
+	if err != nil {
+		return nil, fmt.Errorf("Network %s: %s", name, err)
+	}
+	network := &ipamNetwork{name: name, ipnet: ipnet, reserved: make(map[string]string)}
+	network.reserved[ipnet.IP.String()] = "network address"
+	if ipnet.IP.To4() != nil {
+		network.reserved[utils.BroadcastIP(ipnet).String()] = "broadcast address"
+	}
+	for _, key := range []string{"gateway", "dhcpserver", "nameservers"} {
+		value, _ := attrs[key].(string)
+		for _, item := range strings.Split(value, ",") {
+			if ip := net.ParseIP(item); ip != nil && ipnet.Contains(ip) {
+				network.reserved[ip.String()] = strings.TrimSuffix(key, "s")
+			}
+		}
+	}
+	if dynamic, _ := attrs["dynamic_range"].(string); dynamic != "" {
+		first, last, err := utils.ParseIPRange(dynamic)
+		if err != nil {
+			return nil, fmt.Errorf("Network %s: %s", name, err)
+		}
+		network.dynamic = &ipamRange{first, last, "dynamic range"}
+		network.ranges = append(network.ranges, *network.dynamic)
+	}
+	for _, item := range reserve {
+		if !strings.Contains(item, "-") {
+			item = item + "-" + item
+		}
+		first, last, err := utils.ParseIPRange(item)
+		if err != nil {
+			return nil, err
+		}
+		network.ranges = append(network.ranges, ipamRange{first, last, "reserved"})
+	}
+	return network, nil
+}
+
+// excluded returns why the address is not allocated, or "" when it may be.
+func (network *ipamNetwork) excluded(ip net.IP) string {
+	if reason, ok := network.reserved[ip.String()]; ok {
+		return reason
+	}
+	for _, r := range network.ranges {
+		if utils.CompareIP(r.first, ip) <= 0 && utils.CompareIP(ip, r.last) <= 0 {
+			return r.reason
+		}
+	}
+	return ""
+}
+
+// clip returns the part of the range in the network, false when there is
+// none.
+func (network *ipamNetwork) clip(r ipamRange) (ipamRange, bool) {
+	if first := network.ipnet.IP; utils.CompareIP(r.first, first) < 0 {
+		r.first = first
+	}
+	if last := utils.BroadcastIP(network.ipnet); utils.CompareIP(r.last, last) > 0 {
+		r.last = last
+	}
+	return r, utils.CompareIP(r.first, r.last) <= 0
+}
+
+// merged returns the ranges clipped to the network, sorted and merged where
+// they overlap or touch, so that no address is counted twice.
+func (network *ipamNetwork) merged() []ipamRange {
+	ranges := make([]ipamRange, 0, len(network.ranges))
+	for _, r := range network.ranges {
+		if r, ok := network.clip(r); ok {
+			ranges = append(ranges, r)
+		}
+	}
+	sort.Slice(ranges, func(i, j int) bool { return utils.CompareIP(ranges[i].first, ranges[j].first) < 0 })
+	merged := make([]ipamRange, 0, len(ranges))
+	for _, r := range ranges {
+		if n := len(merged); n > 0 && (utils.CompareIP(r.first, merged[n-1].last) <= 0 ||
+			utils.CompareIP(r.first, utils.NextIP(merged[n-1].last)) == 0) {
+			if utils.CompareIP(r.last, merged[n-1].last) > 0 {
+				merged[n-1].last = r.last
+			}
+			continue
+		}
+		merged = append(merged, r)
+	}
+	return merged
+}
+
+// _list_ipam_nics returns the nics holding each address of the network and the
+// nics of each node.
+func _list_ipam_nics(network *ipamNetwork) (map[string][]map[string]interface{}, map[string][]map[string]interface{}, error) {
+	client, err := NewNicClient()
+	if err != nil {
+		return nil, nil, err
+	}
+	nics, err := client.List(NIC_LIST_FIELDS)
+	if err != nil {
+		return nil, nil, err
+	}
+	used := make(map[string][]map[string]interface{})
+	nodes := make(map[string][]map[string]interface{})
+	for _, nic := range nics {
+		if node, ok := nic["node"].(string); ok {
+			nodes[node] = append(nodes[node], nic)
+		}
+		value, _ := nic["ip"].(string)
+		if ip := net.ParseIP(value); ip != nil && network.ipnet.Contains(ip) {
+			used[ip.String()] = append(used[ip.String()], nic)
+		}
+	}
+	return used, nodes, nil
+}
+
+func _nic_label(nic map[string]interface{}) string {
+	return fmt.Sprintf("%v/%v", nic["node"], nic["name"])
+}
+
+// _ipam_nic returns the nic of the node to allocate an address to, or nil
+// when the nic named by --nic does not exist yet.
+func _ipam_nic(network *ipamNetwork, nics []map[string]interface{}, name string) (map[string]interface{}, error) {
+	if name != "" {
+		for _, nic := range nics {
+			if nic["name"] == name {
+				return nic, nil
+			}
+		}
+		return nil, nil
+	}
+	for _, nic := range nics {
+		value, _ := nic["ip"].(string)
+		if ip := net.ParseIP(value); ip != nil && network.ipnet.Contains(ip) {
+			return nic, nil
+		}
+	}
+	switch len(nics) {
+	case 0:
+		return nil, fmt.Errorf("no nic, please specify --nic and --mac to create one")
+	case 1:
+		return nics[0], nil
+	}
+	return nil, fmt.Errorf("%d nics, please specify --nic", len(nics))
+}
+
+func IpamAllocate(cmd *cobra.Command, args []string) {
+	if len(args) != 2 {
+		fmt.Println("Please specify the network and the node range")
+		os.Exit(1)
+	}
+	network, err := _ipam_network(args[0], ipamAllocateOpts.reserve)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	names, err := _expand_noderange(args[1])
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	used, nodes, err := _list_ipam_nics(network)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	// the mac of the created nics may vary per node like the node attributes
+	var macs *utils.Template
+	if ipamAllocateOpts.mac != "" {
+		if ipamAllocateOpts.nic == "" {
+			fmt.Println("--mac applies to the nic named by --nic.")
+			os.Exit(1)
+		}
+		if macs, err = utils.ParseTemplate(ipamAllocateOpts.mac, len(names)); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+	result := map[string]interface{}{"nodes": make(map[string]interface{})}
+	status := result["nodes"].(map[string]interface{})
+	plan := make([]*ipamAllocation, 0, len(names))
+	next := utils.NextIP(network.ipnet.IP)
+	for i, name := range names {
+		nic, err := _ipam_nic(network, nodes[name], ipamAllocateOpts.nic)
+		if err != nil {
+			status[name] = fmt.Sprintf("Error: %s has %s", name, err)
+			continue
+		}
+		allocation := &ipamAllocation{node: name, nic: ipamAllocateOpts.nic, action: "create"}
+		if nic == nil {
+			// a new nic needs its mac
+			if macs == nil {
+				status[name] = fmt.Sprintf("Error: %s has no nic %s, please specify --mac to create it", name, allocation.nic)
+				continue
+			}
+			if allocation.mac, err = macs.Render(i, name); err != nil {
+				status[name] = fmt.Sprintf("Error: %s", err)
+				continue
+			}
+			if mac, err := net.ParseMAC(allocation.mac); err != nil || len(mac) != 6 {
+				status[name] = fmt.Sprintf("Error: '%s' is not a MAC address", allocation.mac)
+				continue
+			}
+		} else {
+			allocation.nic, _ = nic["name"].(string)
+			allocation.uuid, _ = nic["uuid"].(string)
+			allocation.mac, _ = nic["mac"].(string)
+			allocation.action = "update"
+			value, _ := nic["ip"].(string)
+			// a duplicate or excluded address is replaced, the first nic
+			// holding a duplicate keeps it
+			if ip := net.ParseIP(value); ip != nil && network.ipnet.Contains(ip) &&
+				network.excluded(ip) == "" && used[ip.String()][0]["uuid"] == nic["uuid"] {
+				allocation.ip, allocation.action = ip.String(), "keep"
+				plan = append(plan, allocation)
+				continue
+			}
+		}
+		for ; network.ipnet.Contains(next); next = utils.NextIP(next) {
+			if len(used[next.String()]) == 0 && network.excluded(next) == "" {
+				break
+			}
+		}
+		if !network.ipnet.Contains(next) {
+			status[name] = fmt.Sprintf("Error: no free address left in network %s", network.name)
+			continue
+		}
+		allocation.ip = next.String()
+		used[allocation.ip] = append(used[allocation.ip], map[string]interface{}{"node": name, "name": allocation.nic})
+		plan = append(plan, allocation)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "NODE\tNIC\tMAC\tIP\tACTION")
+	for _, allocation := range plan {
+		mac := allocation.mac
+		if mac == "" {
+			mac = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", allocation.node, allocation.nic, mac, allocation.ip, allocation.action)
+	}
+	w.Flush()
+	if ipamAllocateOpts.dryRun {
+		names = make([]string, 0, len(status))
+		for name := range status {
+			names = append(names, name)
+		}
+		utils.SortNatural(names)
+		for _, name := range names {
+			fmt.Printf("%s: %s\n", name, status[name])
+		}
+		return
+	}
+	fmt.Println()
+
+	client, err := NewNicClient()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	netmask := utils.Netmask(network.ipnet)
+	for _, allocation := range plan {
+		switch allocation.action {
+		case "keep":
+			status[allocation.node] = "ok"
+		case "update":
+			patches := []map[string]interface{}{{"op": "add", "path": "/ip", "value": allocation.ip},
+				{"op": "add", "path": "/netmask", "value": netmask}}
+			if _, err = client.Patch(allocation.uuid, patches, true); err != nil {
+				status[allocation.node] = err.Error()
+			} else {
+				status[allocation.node] = "updated"
+			}
+		case "create":
+			nic := map[string]interface{}{"node": allocation.node, "name": allocation.nic, "mac": allocation.mac,
+				"ip": allocation.ip, "netmask": netmask}
+			if _, err = client.Post("", nic, true); err != nil {
+				status[allocation.node] = err.Error()
+			} else {
+				status[allocation.node] = "ok"
+			}
+		}
+	}
+	_print_node_result(result)
+}
+
+func IpamAllocateCommand() *cobra.Command {
+	ipamAllocateOpts = new(IpamOptions)
+	cmd := &cobra.Command{
+		Use:   "allocate <network name> <node range> [--nic <name>] [--reserve <ip range>] [--dry-run]",
+		Short: "Allocate free addresses of a network to node(s).",
+		Long: `Allocate the next free address of a network to each node. Format:
+		allocate <network name> <node range> [--nic <name>] [--reserve <ip range>] [--dry-run]
+		The addresses of all the nics, the gateway, the dhcp server, the name servers, the dynamic
+		range and the --reserve addresses are skipped. The nic named by --nic is updated, or created
+		with the mac given by --mac when the node does not have it. --mac may vary per node like the
+		node attributes of the create command. Without --nic the nic of the node already in the
+		network, or its only nic, is used. A nic already in the network keeps its address, like
+		allocate mgmt compute --nic eth0 --reserve 10.0.0.1-10.0.0.20 --dry-run
+		allocate mgmt compute[01-40] --nic eth1 --mac [42:87:0a:05:01:01-42:87:0a:05:01:28]`,
+		Run: IpamAllocate,
+	}
+	cmd.Flags().StringVarP(&ipamAllocateOpts.nic, "nic", "", "",
+		`Name of the nic to allocate the address to, like eth0.`)
+	cmd.Flags().StringVarP(&ipamAllocateOpts.mac, "mac", "", "",
+		`MAC address of the nic named by --nic for the node(s) which do not have it, like
+		[42:87:0a:05:01:01-42:87:0a:05:01:28] for one per node.`)
+	cmd.Flags().StringSliceVarP(&ipamAllocateOpts.reserve, "reserve", "", nil,
+		`Address or range of addresses not to allocate, may be repeated.`)
+	cmd.Flags().BoolVarP(&ipamAllocateOpts.dryRun, "dry-run", "n", false,
+		`Only print the addresses which would be allocated.`)
+	_add_selector_flag(cmd)
+	return cmd
+}
+
+func IpamReport(cmd *cobra.Command, args []string) {
+	if len(args) != 1 {
+		fmt.Println("Please specify the network")
+		os.Exit(1)
+	}
+	network, err := _ipam_network(args[0], ipamReportOpts.reserve)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	used, _, err := _list_ipam_nics(network)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	total := utils.NetworkSize(network.ipnet)
+	merged := network.merged()
+	excluded := big.NewInt(0)
+	for _, r := range merged {
+		excluded.Add(excluded, utils.RangeSize(r.first, r.last))
+	}
+	for ip := range network.reserved {
+		// the reserved addresses in a range are counted with the range
+		addr, inRange := net.ParseIP(ip), false
+		for _, r := range merged {
+			if utils.CompareIP(r.first, addr) <= 0 && utils.CompareIP(addr, r.last) <= 0 {
+				inRange = true
+				break
+			}
+		}
+		if !inRange {
+			excluded.Add(excluded, big.NewInt(1))
+		}
+	}
+	dynamic := big.NewInt(0)
+	if network.dynamic != nil {
+		if r, ok := network.clip(*network.dynamic); ok {
+			dynamic = utils.RangeSize(r.first, r.last)
+		}
+	}
+	ips := make([]string, 0, len(used))
+	allocated := 0
+	for ip := range used {
+		ips = append(ips, ip)
+		if network.excluded(net.ParseIP(ip)) == "" {
+			allocated += 1
+		}
+	}
+	sort.Slice(ips, func(i, j int) bool { return utils.CompareIP(net.ParseIP(ips[i]), net.ParseIP(ips[j])) < 0 })
+	usable := new(big.Int).Sub(total, excluded)
+	free := new(big.Int).Sub(usable, big.NewInt(int64(allocated)))
+	utilization := 0.0
+	if usable.Sign() > 0 {
+		utilization, _ = new(big.Rat).SetFrac(big.NewInt(int64(allocated*100)), usable).Float64()
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "Network:\t%s %s\n", network.name, network.ipnet)
+	fmt.Fprintf(w, "Addresses:\t%s\n", total)
+	fmt.Fprintf(w, "Reserved:\t%s\n", new(big.Int).Sub(excluded, dynamic))
+	if network.dynamic != nil {
+		fmt.Fprintf(w, "Dynamic:\t%s (%s-%s)\n", dynamic, network.dynamic.first, network.dynamic.last)
+	}
+	fmt.Fprintf(w, "Allocated:\t%d (%.1f%%)\n", allocated, utilization)
+	fmt.Fprintf(w, "Free:\t%s\n", free)
+	w.Flush()
+
+	conflicts := make([]string, 0)
+	for _, ip := range ips {
+		labels := make([]string, 0, len(used[ip]))
+		for _, nic := range used[ip] {
+			labels = append(labels, _nic_label(nic))
+		}
+		reasons := make([]string, 0, 2)
+		if len(labels) > 1 {
+			reasons = append(reasons, "duplicate")
+		}
+		if reason := network.excluded(net.ParseIP(ip)); reason != "" {
+			reasons = append(reasons, reason)
+		}
+		if len(reasons) > 0 {
+			conflicts = append(conflicts, fmt.Sprintf("  %s: %s (%s)", ip, strings.Join(labels, ", "), strings.Join(reasons, ", ")))
+		}
+	}
+	if len(conflicts) > 0 {
+		fmt.Printf("\nConflicts: %d\n%s\n", len(conflicts), strings.Join(conflicts, "\n"))
+	}
+}
+
+func IpamReportCommand() *cobra.Command {
+	ipamReportOpts = new(IpamOptions)
+	cmd := &cobra.Command{
+		Use:   "report <network name> [--reserve <ip range>]",
+		Short: "Show the address utilization and the conflicts of a network.",
+		Long: `Show the address utilization of a network, and the conflicts: the addresses held by more
+		than one nic, and the nics on the gateway, the dhcp server, the name servers, the dynamic
+		range or the --reserve addresses. Format: report <network name> [--reserve <ip range>]`,
+		Run: IpamReport,
+	}
+	cmd.Flags().StringSliceVarP(&ipamReportOpts.reserve, "reserve", "", nil,
+		`Address or range of addresses not to allocate, may be repeated.`)
+	return cmd
+}
+
+func IpamCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "ipam",
+		Short: "This is ipam child command for xcat3",
+		Long: `xcat3 ipam --help and xcat3 ipam help COMMAND to see the usage for specfied
+	command.`,
+	}
+	return cmd
+}
+
+func init() {
+	IpamCmd := IpamCommand()
+	IpamCmd.AddCommand(IpamAllocateCommand())
+	IpamCmd.AddCommand(IpamReportCommand())
+	RootCmd.AddCommand(IpamCmd)
+}
@@ -0,0 +1,180 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/chenglch/golang-xcat3client/utils"
+	"github.com/spf13/cobra"
+)
+
+// Labels are the key/value pairs in the extra attribute of the nodes, like
+// rack=r12 or role=gpu.
+
+var (
+	labelPattern = regexp.MustCompile(`^[A-Za-z0-9]([-A-Za-z0-9_./]*[A-Za-z0-9])?$`)
+	// selector holds the label selector given with -l. It narrows down the
+	// node range of the node commands.
+	selector string
+)
+
+// _add_selector_flag adds the -l option to a command taking a node range.
+func _add_selector_flag(cmd *cobra.Command) {
+	cmd.Flags().StringVarP(&selector, "selector", "l", "",
+		`Only operate on the node(s) with the labels, like 'rack in (r12,r13),role!=login'.
+		Use the node range 'all' to select from every node.`)
+}
+
+// _label_selector parses the -l option into filters on the extra attribute.
+func _label_selector() ([]*utils.Filter, error) {
+	if selector == "" {
+		return nil, nil
+	}
+	filters, err := utils.ParseSelector(selector)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range filters {
+		f.Path = []string{"extra", f.Key}
+		f.Key = "extra." + f.Key
+	}
+	return filters, nil
+}
+
+// _select_nodes keeps the nodes matching the -l option. The nodes are listed
+// with the selector so that the server filters them by the plain key=value
+// labels.
+func _select_nodes(names []string) ([]string, error) {
+	if selector == "" || len(names) == 0 {
+		return names, nil
+	}
+	matched, err := _list_nodes("", nil)
+	if err != nil {
+		return nil, err
+	}
+	wanted := make(map[string]bool, len(matched))
+	for _, name := range matched {
+		wanted[name] = true
+	}
+	selected := make([]string, 0, len(names))
+	for _, name := range names {
+		if wanted[name] {
+			selected = append(selected, name)
+		}
+	}
+	utils.SortNatural(selected)
+	return selected, nil
+}
+
+// _label_patches turns key=val and key- arguments into patches of the extra
+// attribute. '/' in a key is escaped as required by JSON pointer.
+func _label_patches(args []string) ([]map[string]interface{}, error) {
+	patches := make([]map[string]interface{}, 0, len(args))
+	for _, arg := range args {
+		patch := make(map[string]interface{})
+		var key string
+		if strings.HasSuffix(arg, "-") && !strings.Contains(arg, "=") {
+			key = strings.TrimSuffix(arg, "-")
+			patch["op"] = "remove"
+		} else {
+			items := strings.SplitN(arg, "=", 2)
+			if len(items) != 2 {
+				return nil, fmt.Errorf("Invalid label %s, expected key=val or key-.", arg)
+			}
+			if items[1] != "" && !labelPattern.MatchString(items[1]) {
+				return nil, fmt.Errorf("Invalid label value '%s'.", items[1])
+			}
+			key = items[0]
+			patch["op"] = "add"
+			patch["value"] = items[1]
+		}
+		if !labelPattern.MatchString(key) {
+			return nil, fmt.Errorf("Invalid label key '%s'.", key)
+		}
+		patch["path"] = "/extra/" + strings.Replace(strings.Replace(key, "~", "~0", -1), "/", "~1", -1)
+		patches = append(patches, patch)
+	}
+	return patches, nil
+}
+
+func _show_labels(names []string) {
+	client, err := NewNodeClient()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	wanted := make(map[string]bool, len(names))
+	for _, name := range names {
+		wanted[name] = true
+	}
+	nodes, err := _node_attrs(client, []string{"extra"}, nil, wanted)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	labels := make(map[string]string, len(nodes))
+	for _, node := range nodes {
+		extra := utils.InterfaceToMap(node["extra"])
+		pairs := make([]string, 0, len(extra))
+		for k, v := range extra {
+			if _, ok := v.(string); ok {
+				pairs = append(pairs, fmt.Sprintf("%s=%s", k, v))
+			}
+		}
+		sort.Strings(pairs)
+		labels[node["name"].(string)] = strings.Join(pairs, ",")
+	}
+	for _, name := range names {
+		fmt.Printf("%s: %s\n", name, labels[name])
+	}
+}
+
+func LabelNodes(cmd *cobra.Command, args []string) {
+	if len(args) < 1 {
+		fmt.Println("label command should accept node(s) and labels like key=val or key- as the arguments.")
+		os.Exit(1)
+	}
+	patches, err := _label_patches(args[1:])
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	names, err := _expand_noderange(args[0])
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if len(patches) == 0 {
+		_show_labels(names)
+		return
+	}
+	data := make(map[string]interface{})
+	nodes := make([]interface{}, 0, len(names))
+	for _, name := range names {
+		nodes = append(nodes, map[string]string{"name": name})
+	}
+	data["nodes"] = nodes
+	data["patches"] = patches
+	_print_node_result(_bulk_update(data))
+}
+
+func LabelCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "label <node range> [<key=val>] [<key->]",
+		Short: "Add, remove or show the labels of node(s).",
+		Long: `Add, remove or show the labels of node(s). Format: label <node range> [<key=val>] [<key->]
+		key=val sets a label and key- removes it. Without labels the current labels are shown.
+		Labels are stored in the extra attribute and select node(s) with the -l option of the
+		commands taking a node range, like power all on -l 'rack in (r12,r13),role!=login'`,
+		Run: LabelNodes,
+	}
+	_add_selector_flag(cmd)
+	return cmd
+}
+
+func init() {
+	RootCmd.AddCommand(LabelCommand())
+}
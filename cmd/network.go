@@ -2,6 +2,7 @@ package cmd
 
 import (
 	"fmt"
+	"net"
 	"os"
 	"strings"
 
@@ -87,27 +88,147 @@ func ShowNetworkCommand() *cobra.Command {
 	return cmd
 }
 
+// _check_address checks that the address is in the network and is not its
+// network or broadcast address. Values in angle brackets like <xcatmaster>
+// are resolved by the server and not checked.
+func _check_address(ipnet *net.IPNet, key string, value string) (net.IP, error) {
+	if strings.HasPrefix(value, "<") && strings.HasSuffix(value, ">") {
+		return nil, nil
+	}
+	ip := net.ParseIP(value)
+	if ip == nil {
+		return nil, fmt.Errorf("Invalid %s %s.", key, value)
+	}
+	if !ipnet.Contains(ip) {
+		return nil, fmt.Errorf("The %s %s is not in the subnet %s.", key, value, ipnet)
+	}
+	if ip.Equal(ipnet.IP) || (ipnet.IP.To4() != nil && ip.Equal(utils.BroadcastIP(ipnet))) {
+		return nil, fmt.Errorf("The %s %s is the network or the broadcast address of %s.", key, value, ipnet)
+	}
+	return ip, nil
+}
+
+// _validate_network checks the addresses of the network attributes against
+// its subnet, and returns the subnet.
+func _validate_network(attrs map[string]interface{}) (*net.IPNet, error) {
+	subnet, _ := attrs["subnet"].(string)
+	netmask, _ := attrs["netmask"].(string)
+	if subnet == "" {
+		return nil, fmt.Errorf("Please specify the subnet like 10.0.0.0/22 or subnet=10.0.0.0 netmask=255.255.252.0")
+	}
+	ipnet, err := utils.ParseNetwork(subnet, netmask)
+	if err != nil {
+		return nil, err
+	}
+	fixed := make([]net.IP, 0, 2)
+	for _, key := range []string{"gateway", "dhcpserver"} {
+		if value, ok := attrs[key].(string); ok && value != "" {
+			ip, err := _check_address(ipnet, key, value)
+			if err != nil {
+				return nil, err
+			}
+			if ip != nil {
+				fixed = append(fixed, ip)
+			}
+		}
+	}
+	if nameservers, ok := attrs["nameservers"].(string); ok && nameservers != "" {
+		for _, value := range strings.Split(nameservers, ",") {
+			if net.ParseIP(value) == nil && !strings.HasPrefix(value, "<") {
+				return nil, fmt.Errorf("Invalid nameserver %s.", value)
+			}
+		}
+	}
+	if dynamic, ok := attrs["dynamic_range"].(string); ok && dynamic != "" {
+		first, last, err := utils.ParseIPRange(dynamic)
+		if err != nil {
+			return nil, err
+		}
+		for _, ip := range []net.IP{first, last} {
+			if _, err = _check_address(ipnet, "dynamic range", ip.String()); err != nil {
+				return nil, err
+			}
+		}
+		for _, ip := range fixed {
+			if utils.CompareIP(first, ip) <= 0 && utils.CompareIP(ip, last) <= 0 {
+				return nil, fmt.Errorf("The dynamic range %s holds %s, the gateway or the dhcp server.", dynamic, ip)
+			}
+		}
+	}
+	return ipnet, nil
+}
+
+// _check_overlap rejects a subnet sharing addresses with a registered network.
+func _check_overlap(client *NetworkClient, name string, ipnet *net.IPNet) error {
+	networks, err := _list_records(&client.XCAT3Client, "networks")
+	if err != nil {
+		return err
+	}
+	for _, network := range networks {
+		subnet, _ := network["subnet"].(string)
+		netmask, _ := network["netmask"].(string)
+		other, err := utils.ParseNetwork(subnet, netmask)
+		if err != nil || network["name"] == name {
+			continue
+		}
+		if utils.NetworksOverlap(ipnet, other) {
+			return fmt.Errorf("The subnet %s overlaps with the network %s (%s).", ipnet, network["name"], other)
+		}
+	}
+	return nil
+}
+
 func CreateNetwork(cmd *cobra.Command, args []string) {
 	var result interface{}
-	attr_map, err := utils.KeyValueArrayToMap(args[1:], "=")
+	if len(args) < 2 {
+		fmt.Println("Pleace specify the name of network and its subnet like 10.0.0.0/22 or the attribute key values in key1=val1 key2=val2 format")
+		os.Exit(1)
+	}
+	kvs := args[1:]
+	cidr := ""
+	if !strings.Contains(args[1], "=") {
+		cidr, kvs = args[1], args[2:]
+	}
+	attr_map, err := utils.KeyValueArrayToMap(kvs)
 	if err != nil {
 		fmt.Println(err)
 		os.Exit(1)
 	}
 	attr_map["name"] = args[0]
+	if cidr != "" {
+		if _, ok := attr_map["subnet"]; ok {
+			fmt.Println("Please specify the subnet either in CIDR notation or with subnet=")
+			os.Exit(1)
+		}
+		if _, ok := attr_map["netmask"]; ok {
+			fmt.Println("Please specify the netmask either in CIDR notation or with netmask=")
+			os.Exit(1)
+		}
+		if !strings.Contains(cidr, "/") {
+			fmt.Printf("Invalid subnet %s, expected CIDR notation like 10.0.0.0/22 or fd00::/64\n", cidr)
+			os.Exit(1)
+		}
+		attr_map["subnet"] = cidr
+	}
+	ipnet, err := _validate_network(attr_map)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	attr_map["subnet"] = ipnet.IP.String()
+	attr_map["netmask"] = utils.Netmask(ipnet)
 	client, err := NewNetworkClient()
 	if err != nil {
 		fmt.Println(err)
 		os.Exit(1)
 	}
-	if len(attr_map) > 1 {
-		result, err = client.Post("", attr_map, true)
-		if err != nil {
-			fmt.Println(err)
-			os.Exit(1)
-		}
-	} else {
-		fmt.Println("Pleace specify the attribute key values in key1=val1 key2=val2 format")
+	if err = _check_overlap(client, args[0], ipnet); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	result, err = client.Post("", attr_map, true)
+	if err != nil {
+		fmt.Println(err)
 		os.Exit(1)
 	}
 	utils.PrintJson(result)
@@ -115,11 +236,16 @@ func CreateNetwork(cmd *cobra.Command, args []string) {
 
 func CreateNetworkCommand() *cobra.Command {
 	cmd := &cobra.Command{
-		Use:   "create <network name> <key=val> [key=val]",
+		Use:   "create <network name> [<subnet/prefix length>] <key=val> [key=val]",
 		Short: "Register network into xCAT3 service.",
-		Long: `Register network into xCAT3 service. Format: create <network name> <key=val> [key=val]
+		Long: `Register network into xCAT3 service. Format: create <network name> [<subnet/prefix length>] <key=val> [key=val]
 		Current valid fields 'subnet', 'netmask', 'gateway', 'dhcpserver', 'dynamic_range',
-                'nameservers', 'domain'`,
+                'nameservers', 'domain'
+		The subnet and the netmask are computed from the CIDR notation, for IPv4 or IPv6, like
+		create mgmt 10.0.0.0/22 gateway=10.0.0.1 dynamic_range=10.0.3.1-10.0.3.254
+		create mgmt6 fd00:10::/64 gateway=fd00:10::1
+		The gateway, the dhcp server and the dynamic range must be in the subnet, and the subnet
+		must not overlap with the registered networks.`,
 		Run: CreateNetwork,
 	}
 	return cmd
@@ -159,7 +285,11 @@ func UpdateNetwork(cmd *cobra.Command, args []string) {
 		fmt.Println("Please specify the name of network and attribute in key=val format to update")
 		os.Exit(1)
 	}
-	patches := arg_array_to_patch(args[1:])
+	patches, err := arg_array_to_patch(args[1:])
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
 	client, err := NewNetworkClient()
 	if err != nil {
 		fmt.Println(err)
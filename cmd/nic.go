@@ -97,7 +97,7 @@ func ShowNicCommand() *cobra.Command {
 
 func CreateNics(cmd *cobra.Command, args []string) {
 	var result interface{}
-	attr_map, err := utils.KeyValueArrayToMap(args, "=")
+	attr_map, err := utils.KeyValueArrayToMap(args)
 	if err != nil {
 		fmt.Println(err)
 		os.Exit(1)
@@ -182,7 +182,11 @@ func UpdateNic(cmd *cobra.Command, args []string) {
 		fmt.Println("Please specify the uuid of nic and attribute in key=val format to update")
 		os.Exit(1)
 	}
-	patches := arg_array_to_patch(args[1:])
+	patches, err := arg_array_to_patch(args[1:])
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
 	client, err := NewNicClient()
 	if err != nil {
 		fmt.Println(err)
@@ -47,3 +47,20 @@ func (client *NicClient) GetByMac(mac string, fields []string) (interface{}, err
 	}
 	return result, nil
 }
+
+// List returns the nics with only the given fields.
+func (client *NicClient) List(fields []string) ([]map[string]interface{}, error) {
+	params := url.Values{}
+	if len(fields) > 0 {
+		params.Set("fields", strings.Join(fields, ","))
+	}
+	result, err := client.Sess.Get(client.Resource, &params, nil, false)
+	if err != nil {
+		return nil, err
+	}
+	nics := make([]map[string]interface{}, 0)
+	for _, nic := range utils.InterfaceToSlice(utils.InterfaceToMap(result)["nics"]) {
+		nics = append(nics, utils.InterfaceToMap(nic))
+	}
+	return nics, nil
+}
@@ -1,11 +1,15 @@
 package cmd
 
 import (
+	"encoding/json"
 	"fmt"
+	"net/url"
 	"os"
 	"runtime"
 	"strings"
 	"sync"
+	"text/tabwriter"
+	"time"
 
 	"github.com/chenglch/golang-xcat3client/utils"
 	"github.com/spf13/cobra"
@@ -16,6 +20,10 @@ type CreateNodeOptions struct {
 	control string
 }
 
+type ListNodeOptions struct {
+	where []string
+}
+
 type ShowNodeOptions struct {
 	fields string
 }
@@ -24,62 +32,107 @@ type ExportNodeOptions struct {
 	filepath string
 }
 
+type PowerNodeOptions struct {
+	wait     bool
+	timeout  time.Duration
+	interval time.Duration
+	waves    WaveOptions
+}
+
+type BootDevOptions struct {
+	persistent bool
+	uefi       bool
+	legacy     bool
+}
+
 type DeployNodeOptions struct {
-	state   string
-	osimage string
-	delete  bool
+	state    string
+	osimage  string
+	delete   bool
+	mapFile  string
+	attr     string
+	bootdev  bool
+	boot     bool
+	wait     bool
+	target   string
+	timeout  time.Duration
+	interval time.Duration
+	waves    WaveOptions
 }
 
 var (
 	createOpts      *CreateNodeOptions
+	cloneOpts       *CreateNodeOptions
 	SUCCESS_RESULTS = map[string]bool{"ok": true,
 		"updated":   true,
 		"deleted":   true,
 		"on":        true,
 		"off":       true,
+		"reset":     true,
+		"softoff":   true,
+		"cycle":     true,
+		"nmi":       true,
 		"net":       true,
 		"cdrom":     true,
 		"disk":      true,
 		"provision": true}
 	FIELD_MAP = map[string]string{"control": "control_info",
 		"nics": "nics_info"}
-	showOpts   *ShowNodeOptions
-	exportOpts *ExportNodeOptions
-	deployOpts *DeployNodeOptions
+	listOpts    *ListNodeOptions
+	showOpts    *ShowNodeOptions
+	exportOpts  *ExportNodeOptions
+	powerOpts   *PowerNodeOptions
+	bootDevOpts *BootDevOptions
+	deployOpts  *DeployNodeOptions
 
-	exportFields     = []string{"name", "mgt", "netboot", "type", "arch", "nics_info", "control_info"}
+	exportFields     = []string{"name", "mgt", "netboot", "type", "arch", "groups", "nics_info", "control_info"}
 	allowBootDev     = []string{"disk", "net", "cdrom", "status"}
-	allowPowerStatus = []string{"on", "off", "boot", "status"}
+	allowPowerStatus = []string{"on", "off", "boot", "reset", "softoff", "cycle", "nmi", "status"}
+	// POWER_TARGET_STATES is the state --wait expects after each action.
+	POWER_TARGET_STATES = map[string]string{"on": "on",
+		"off":     "off",
+		"softoff": "off",
+		"boot":    "on",
+		"reset":   "on",
+		"cycle":   "on"}
+	// POWER_CYCLE_ACTIONS turn the node(s) off before the target state, so
+	// --wait needs to see the node(s) already in this state change again. A
+	// reset keeps the power on, it is done once the node is on.
+	POWER_CYCLE_ACTIONS = map[string]bool{"boot": true, "cycle": true}
 )
 
-func arg_array_to_patch(args []string) []map[string]string {
-	patches := make([]map[string]string, 0)
+func arg_array_to_patch(args []string) ([]map[string]interface{}, error) {
+	patches := make([]map[string]interface{}, 0)
 	for _, arg := range args {
-		if !strings.HasPrefix(arg, "/") {
-			arg = "/" + arg
-		}
-		items := strings.Split(arg, "=")
-		path := items[0]
-		value := items[1]
-		patch := make(map[string]string)
-		if value != "" {
+		kv, err := utils.ParseKeyValue(strings.TrimPrefix(arg, "/"))
+		if err != nil {
+			return nil, err
+		}
+		patch := make(map[string]interface{})
+		patch["path"] = "/" + strings.Join(kv.Path, "/")
+		if kv.Value != "" {
 			patch["op"] = "add"
-			patch["path"] = path
-			patch["value"] = value
+			patch["value"] = kv.Value
 		} else {
 			patch["op"] = "remove"
-			patch["path"] = path
 		}
 		patches = append(patches, patch)
 	}
-	return patches
+	return patches, nil
 }
 
 func _print_node_result(ret map[string]interface{}) {
 	var success uint64
 	var failed uint64
-	for k, v := range utils.InterfaceToMap(ret["nodes"]) {
-		if _, ok := SUCCESS_RESULTS[v.(string)]; ok {
+	nodes := utils.InterfaceToMap(ret["nodes"])
+	names := make([]string, 0, len(nodes))
+	for k := range nodes {
+		names = append(names, k)
+	}
+	utils.SortNatural(names)
+	for _, k := range names {
+		v := nodes[k]
+		if _, ok := SUCCESS_RESULTS[fmt.Sprint(v)]; ok {
 			success += 1
 		} else {
 			failed += 1
@@ -119,7 +172,10 @@ func _patch(data map[string]interface{}, result map[string]interface{}, wg *sync
 	defer wg.Done()
 }
 
-func _parallel_create(data map[string]interface{}) map[string]interface{} {
+// _parallel_nodes splits the nodes of data into 4 parts and runs the worker
+// on each part in parallel, then merges the results of the nodes. The other
+// keys of data, like the patches, are passed with every part.
+func _parallel_nodes(data map[string]interface{}, worker func(map[string]interface{}, map[string]interface{}, *sync.WaitGroup)) map[string]interface{} {
 	// split into 4 parts
 	part_num := 4
 	i := 0
@@ -138,10 +194,13 @@ func _parallel_create(data map[string]interface{}) map[string]interface{} {
 	for i := 0; i < part_num; i++ {
 		wg.Add(1)
 		dataMap := make(map[string]interface{})
+		for key, value := range data {
+			dataMap[key] = value
+		}
 		results[i] = make(map[string]interface{})
 		results[i]["nodes"] = make(map[string]interface{})
 		dataMap["nodes"] = nodes[i]
-		go _post(dataMap, results[i], &wg)
+		go worker(dataMap, results[i], &wg)
 	}
 	wg.Wait()
 	ret := make(map[string]interface{})
@@ -152,105 +211,132 @@ func _parallel_create(data map[string]interface{}) map[string]interface{} {
 	return ret
 }
 
+func _parallel_create(data map[string]interface{}) map[string]interface{} {
+	return _parallel_nodes(data, _post)
+}
+
 func _parallel_update(data map[string]interface{}) map[string]interface{} {
-	// split into 4 parts
-	part_num := 4
-	i := 0
-	nodes := make([]interface{}, part_num)
-	size := len(data["nodes"].([]interface{}))
-	per_size := size / part_num
-	elements := utils.InterfaceToSlice(data["nodes"])
-	for i = 0; i < part_num-1; i++ {
-		nodes[i] = elements[i*per_size : (i+1)*per_size]
+	return _parallel_nodes(data, _patch)
+}
+
+// _render_nodes builds the node list for the names, interpolating the per
+// node expressions of the template. Nothing is returned unless every node
+// renders.
+func _render_nodes(names []string, template map[string]interface{}) ([]interface{}, error) {
+	interpolator := utils.NewInterpolator(names)
+	nodes := make([]interface{}, 0, len(names))
+	for i, name := range names {
+		rendered, err := interpolator.Render(template, i)
+		if err != nil {
+			return nil, err
+		}
+		node := rendered.(map[string]interface{})
+		node["name"] = name
+		nodes = append(nodes, node)
 	}
-	nodes[i] = elements[i*per_size:]
-	patches := data["patches"]
+	return nodes, nil
+}
 
-	var wg sync.WaitGroup
-	runtime.GOMAXPROCS(part_num)
-	results := make([]map[string]interface{}, part_num)
-	for i := 0; i < part_num; i++ {
-		wg.Add(1)
-		dataMap := make(map[string]interface{})
-		results[i] = make(map[string]interface{})
-		results[i]["nodes"] = make(map[string]interface{})
-		dataMap["nodes"] = nodes[i]
-		dataMap["patches"] = patches
-		go _patch(dataMap, results[i], &wg)
+// _bulk_create posts the nodes, in parallel when there are many of them.
+// The secret references are resolved from the local secret store first.
+func _bulk_create(data map[string]interface{}) map[string]interface{} {
+	if err := utils.ResolveSecrets(data); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
 	}
-	wg.Wait()
-	ret := make(map[string]interface{})
-	ret["nodes"] = make(map[string]interface{})
-	for i := 0; i < part_num; i++ {
-		utils.MergeMap(ret["nodes"].(map[string]interface{}), results[i]["nodes"].(map[string]interface{}))
+	if len(data["nodes"].([]interface{})) >= 3000 {
+		return _parallel_create(data)
 	}
-	return ret
+	client, err := NewNodeClient()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	result, err := client.Post("", data)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	return result
 }
 
-func CreateNodes(cmd *cobra.Command, args []string) {
-	var nics []interface{}
-	var control map[string]interface{}
-	var err error
-	if len(createOpts.nics) != 0 {
-		nics, err = utils.KeyValueArrayToMapArray(createOpts.nics)
+// _bulk_update patches the nodes, in parallel when there are many of them.
+func _bulk_update(data map[string]interface{}) map[string]interface{} {
+	if len(data["nodes"].([]interface{})) >= 3000 {
+		return _parallel_update(data)
+	}
+	client, err := NewNodeClient()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	result, err := client.Patch("", data)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	return result
+}
+
+// _node_template turns the --nic and --control options and the key=val
+// arguments into the node attributes.
+func _node_template(nicArgs []string, controlArg string, attrArgs []string) (map[string]interface{}, error) {
+	template := make(map[string]interface{})
+	if len(nicArgs) != 0 {
+		nics, err := utils.KeyValueArrayToMapArray(nicArgs)
 		if err != nil {
-			fmt.Println(err)
-			os.Exit(1)
+			return nil, err
 		}
+		template["nics_info"] = map[string]interface{}{"nics": nics}
 	}
-	if createOpts.control != "" {
-		control, err = utils.KeyValueToMap(createOpts.control, ",")
+	if controlArg != "" {
+		control, err := utils.KeyValueToMap(controlArg)
 		if err != nil {
-			fmt.Println(err)
-			os.Exit(1)
+			return nil, err
+		}
+		template["control_info"] = control
+	}
+	attr_map, err := utils.KeyValueArrayToMap(attrArgs)
+	if err != nil {
+		return nil, err
+	}
+	for key, field := range FIELD_MAP {
+		if value, ok := attr_map[key]; ok {
+			delete(attr_map, key)
+			attr_map[field] = value
 		}
 	}
+	utils.DeepMergeMap(template, attr_map)
+	return template, nil
+}
+
+func CreateNodes(cmd *cobra.Command, args []string) {
 	if len(args) == 0 {
 		fmt.Println("Could not find node argument")
 		os.Exit(1)
 	}
+	template, err := _node_template(createOpts.nics, createOpts.control, args[1:])
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
 	names, err := utils.ToNodeArray(args[0])
 	if err != nil {
 		fmt.Println(err)
 		os.Exit(1)
 	}
-	attr_map, err := utils.KeyValueArrayToMap(args[1:], "=")
+	nodes, err := _render_nodes(names, template)
 	if err != nil {
 		fmt.Println(err)
 		os.Exit(1)
 	}
-	var nodes []interface{}
-	for _, name := range names {
-		node := make(map[string]interface{})
-		node["name"] = name
-		if control != nil {
-			node["control_info"] = control
-		}
-		if nics != nil {
-			nics_dict := map[string]interface{}{"nics": nics}
-			node["nics_info"] = nics_dict
-		}
-		utils.MergeMap(node, attr_map)
-		nodes = append(nodes, node)
+	if err = NODE_SCHEMAS[CURRENT_SCHEMA_VERSION].ValidateNodes(nodes); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
 	}
 	data := make(map[string]interface{})
 	data["nodes"] = nodes
-	var result map[string]interface{}
-	if len(data["nodes"].([]interface{})) < 3000 {
-		client, err := NewNodeClient()
-		if err != nil {
-			fmt.Println(err)
-			os.Exit(1)
-		}
-		result, err = client.Post("", data)
-		if err != nil {
-			fmt.Println(err)
-			os.Exit(1)
-		}
-	} else {
-		result = _parallel_create(data)
-	}
-	_print_node_result(result)
+	_print_node_result(_bulk_create(data))
 }
 
 func CreateCommand() *cobra.Command {
@@ -269,55 +355,362 @@ func CreateCommand() *cobra.Command {
 	cmd.Flags().StringVarP(&createOpts.control, "control", "c", "",
 		`Key/value pairs split by comma used by the control plugin, such as
 		bmc_address=11.0.0.0,bmc_password=password,bmc_username=admin`)
+	cmd.Long += `
+
+		Keys may be nested with dots like control.bmc_username=admin and typed like vlan:int=10
+		(str, int, float or bool). Quote or escape values holding commas or '=' with '...' or \.
+
+		Values may vary per node. {index}, {num}, {rack} and {slot} are replaced with the
+		position of the node in the range and the numbers in the node name, optionally with
+		arithmetic and a format like {index+10} or {index:02x}. A range like [11-110],
+		[42:87:0a:05:00:00-42:87:0a:05:00:63] or [10.0.0.11-10.0.0.110] assigns one value
+		per node and must be as long as the node range. Write {{ or [[ for a literal brace or
+		bracket. For example:
+		create node[1-100] --nic mac=[42:87:0a:05:00:00-42:87:0a:05:00:63],ip=10.0.0.{num+10} \
+		    --control bmc_address=11.0.{index/50}.{index%50}`
 	return cmd
 }
 
-func ListNodes(cmd *cobra.Command, args []string) {
+// CLONE_STRIP_FIELDS lists the attributes which identify a node and must not
+// be copied from the source node by clone.
+var CLONE_STRIP_FIELDS = map[string][]string{
+	"nics":         {"uuid", "mac", "ip"},
+	"control_info": {"bmc_address"},
+}
+
+func _clone_source(name string) (map[string]interface{}, error) {
 	client, err := NewNodeClient()
+	if err != nil {
+		return nil, err
+	}
+	result, err := client.Show([]string{name}, exportFields)
+	if err != nil {
+		return nil, err
+	}
+	var source map[string]interface{}
+	if err := json.Unmarshal(result.([]byte), &source); err != nil {
+		return nil, err
+	}
+	if nodes, ok := source["nodes"].([]interface{}); ok {
+		if len(nodes) == 0 {
+			return nil, fmt.Errorf("Could not find node %s", name)
+		}
+		source = utils.InterfaceToMap(nodes[0])
+	}
+	delete(source, "name")
+	delete(source, "uuid")
+	if control, ok := source["control_info"].(map[string]interface{}); ok {
+		for _, field := range CLONE_STRIP_FIELDS["control_info"] {
+			delete(control, field)
+		}
+	}
+	if nics_info, ok := source["nics_info"].(map[string]interface{}); ok {
+		for _, nic := range utils.InterfaceToSlice(nics_info["nics"]) {
+			for _, field := range CLONE_STRIP_FIELDS["nics"] {
+				delete(nic.(map[string]interface{}), field)
+			}
+		}
+	}
+	return source, nil
+}
+
+// _clone_nodes renders the overrides for each node and merges them onto a copy
+// of the source node. Only the overrides are interpolated, so that a stored
+// value like a bmc_password holding {index} or [1-4] is copied as it is.
+func _clone_nodes(names []string, source map[string]interface{}, overrides map[string]interface{}) ([]interface{}, error) {
+	content, err := json.Marshal(source)
+	if err != nil {
+		return nil, err
+	}
+	nodes, err := _render_nodes(names, overrides)
+	if err != nil {
+		return nil, err
+	}
+	for i, node := range nodes {
+		var copied map[string]interface{}
+		if err = json.Unmarshal(content, &copied); err != nil {
+			return nil, err
+		}
+		utils.DeepMergeMap(copied, node.(map[string]interface{}))
+		nodes[i] = copied
+	}
+	return nodes, nil
+}
+
+func CloneNodes(cmd *cobra.Command, args []string) {
+	if len(args) < 2 {
+		fmt.Println("clone command should accept the source node and the new node(s) as the arguments.")
+		os.Exit(1)
+	}
+	overrides, err := _node_template(cloneOpts.nics, "", args[2:])
 	if err != nil {
 		fmt.Println(err)
 		os.Exit(1)
 	}
-	ret, err := client.Get("", nil)
+	names, err := utils.ToNodeArray(args[1])
 	if err != nil {
 		fmt.Println(err)
 		os.Exit(1)
 	}
-
-	nodeSlice := utils.InterfaceToSlice(ret.(map[string]interface{})["nodes"])
-	if len(nodeSlice) == 0 {
-		fmt.Println("Could not find any record")
+	source, err := _clone_source(args[0])
+	if err != nil {
+		fmt.Println(err)
 		os.Exit(1)
 	}
-
-	if len(args) == 0 {
-		for _, value := range nodeSlice {
-			fmt.Printf("%s (node)\n", value)
-		}
-	} else if len(args) == 1 {
-		names, err := utils.ToNodeArray(args[0])
+	if cloneOpts.control != "" {
+		control, err := utils.KeyValueToMap(cloneOpts.control)
 		if err != nil {
 			fmt.Println(err)
+			os.Exit(1)
 		}
-		for _, value := range nodeSlice {
-			// TODO(chenglch): Use map instread of slice to test if exist in the qeury list
-			if ok, _ := utils.Contains(value, names); ok {
-				fmt.Printf("%s (node)\n", value)
+		template := map[string]interface{}{"control_info": control}
+		utils.DeepMergeMap(template, overrides)
+		overrides = template
+	}
+	nodes, err := _clone_nodes(names, source, overrides)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if err = NODE_SCHEMAS[CURRENT_SCHEMA_VERSION].ValidateNodes(nodes); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	data := make(map[string]interface{})
+	data["nodes"] = nodes
+	_print_node_result(_bulk_create(data))
+}
+
+func CloneCommand() *cobra.Command {
+	cloneOpts = new(CreateNodeOptions)
+	cmd := &cobra.Command{
+		Use:   "clone <source node> <node range> [--nic <key=val,key=val>] [--control <key=val,key=val>] [<key=val> <key=val>]",
+		Short: "Enroll node(s) with the settings of an existing node.",
+		Long: `Enroll node(s) with the settings of an existing node. The name, uuid, mac and ip of
+		the nics and the bmc_address of the source node are not copied.
+		Format: clone <source node> <node range> [--nic <key=val,key=val>] [--control <key=val,key=val>] [<key=val> <key=val>]
+		--nic replaces the nics of the source node, --control and key=val override its attributes.
+		Values may vary per node in the same way as the create command, like
+		clone node1 node[2-20] --nic mac=[42:87:0a:05:00:02-42:87:0a:05:00:14],ip=10.0.0.{num},name=eth0`,
+		Run: CloneNodes,
+	}
+	cmd.Flags().StringArrayVarP(&cloneOpts.nics, "nic", "i", []string{},
+		`Key/value pairs split by comma to indicate network information, like:
+		-i mac=42:87:0a:05:00:00,primary=True,name=eth0`)
+	cmd.Flags().StringVarP(&cloneOpts.control, "control", "c", "",
+		`Key/value pairs split by comma merged into the control information of the source node, such as
+		bmc_address=11.0.0.{num}`)
+	return cmd
+}
+
+// _node_attrs returns the fields of the nodes in wanted, or of all the nodes
+// when wanted is nil. The params are passed to the server as filters. When
+// the service only returns the node names the fields are fetched by name.
+func _node_attrs(client *NodeClient, fields []string, params url.Values, wanted map[string]bool) ([]map[string]interface{}, error) {
+	nodes, err := client.List(fields, params)
+	if err != nil {
+		return nil, err
+	}
+	attrs := make([]map[string]interface{}, 0, len(nodes))
+	names := make([]string, 0)
+	for _, node := range nodes {
+		if name, ok := node.(string); ok {
+			if wanted == nil || wanted[name] {
+				names = append(names, name)
 			}
+			continue
 		}
+		node_map := utils.InterfaceToMap(node)
+		if name, _ := node_map["name"].(string); wanted == nil || wanted[name] {
+			attrs = append(attrs, node_map)
+		}
+	}
+	if len(names) == 0 {
+		return attrs, nil
+	}
+	if len(fields) == 0 {
+		for _, name := range names {
+			attrs = append(attrs, map[string]interface{}{"name": name})
+		}
+		return attrs, nil
+	}
+	nodes_attrs, err := _show_nodes(client, names, fields)
+	if err != nil {
+		return nil, err
+	}
+	return append(attrs, nodes_attrs...), nil
+}
+
+// _show_nodes fetches the fields of the named nodes only.
+func _show_nodes(client *NodeClient, names []string, fields []string) ([]map[string]interface{}, error) {
+	result, err := client.Show(names, fields)
+	if err != nil {
+		return nil, err
+	}
+	var data interface{}
+	if err = json.Unmarshal(result.([]byte), &data); err != nil {
+		return nil, err
+	}
+	if len(names) == 1 {
+		return []map[string]interface{}{utils.InterfaceToMap(data)}, nil
+	}
+	nodes := utils.InterfaceToSlice(utils.InterfaceToMap(data)["nodes"])
+	attrs := make([]map[string]interface{}, 0, len(nodes))
+	for _, node := range nodes {
+		attrs = append(attrs, utils.InterfaceToMap(node))
+	}
+	return attrs, nil
+}
+
+// _list_nodes returns the names of the nodes in the node range, or of all the
+// nodes when the range is empty, which match the filters. The nodes of a
+// range are fetched by name. Otherwise plain key=value filters are sent to
+// the server, and every filter is applied again on the client for the
+// servers which do not support them.
+func _list_nodes(noderange string, filters []*utils.Filter) ([]string, error) {
+	client, err := NewNodeClient()
+	if err != nil {
+		return nil, err
+	}
+	if noderange == "" {
+		selector, err := _label_selector()
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, selector...)
+	}
+	params := url.Values{}
+	fields := make([]string, 0)
+	for _, f := range filters {
+		if f.IsEqual() {
+			params.Add(f.Key, f.Values[0])
+		}
+		if exist, _ := utils.Contains(fields, f.Path[0]); !exist {
+			fields = append(fields, f.Path[0])
+		}
+	}
+	var nodes []map[string]interface{}
+	if noderange != "" {
+		names, err := _expand_noderange(noderange)
+		if err != nil {
+			return nil, err
+		}
+		if len(fields) == 0 {
+			fields = []string{"name"}
+		}
+		if nodes, err = _show_nodes(client, names, fields); err != nil {
+			return nil, err
+		}
+	} else if nodes, err = _node_attrs(client, fields, params, nil); err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(nodes))
+	for _, node := range nodes {
+		if utils.MatchAll(filters, node) {
+			names = append(names, node["name"].(string))
+		}
+	}
+	utils.SortNatural(names)
+	return names, nil
+}
+
+func ListNodes(cmd *cobra.Command, args []string) {
+	var filters []*utils.Filter
+	for _, where := range listOpts.where {
+		f, err := utils.ParseFilter(where)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		filters = append(filters, f)
+	}
+	noderange := ""
+	if len(args) == 1 {
+		noderange = args[0]
+	}
+	names, err := _list_nodes(noderange, filters)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if len(names) == 0 {
+		fmt.Println("Could not find any record")
+		os.Exit(1)
+	}
+	for _, name := range names {
+		fmt.Printf("%s (node)\n", name)
 	}
 }
 
 func ListCommand() *cobra.Command {
+	listOpts = new(ListNodeOptions)
 	cmd := &cobra.Command{
-		Use:   "list [<node range>]",
+		Use:   "list [<node range>] [--where <filter>]",
 		Short: "List node(s) in xCAT3 service",
-		Long:  `List node(s) in xCAT3 service. Format list [<node range>]`,
-		Run:   ListNodes,
-	}
+		Long: `List node(s) in xCAT3 service. Format list [<node range>] [--where <filter>]
+		A filter is key=value, key!=value, key~regex, key!~regex, 'key in (a,b)' or 'key notin (a,b)',
+		like list --where arch=ppc64le --where mgt=ipmi --where 'control_info.bmc_address~^11\.0\.'`,
+		Run: ListNodes,
+	}
+	cmd.Flags().StringArrayVarP(&listOpts.where, "where", "w", []string{},
+		`Only list the node(s) matching the filter. Can be specified multiple times.`)
+	_add_selector_flag(cmd)
 	return cmd
 }
 
+// _export_secrets replaces the secrets of the nodes in json format with their
+// secret://<name> reference from the local secret store, and removes the ones
+// not in the store, so that the exported file holds no secret.
+func _export_secrets(result []byte) ([]byte, error) {
+	var data interface{}
+	if err := json.Unmarshal(result, &data); err != nil {
+		return nil, err
+	}
+	var store *utils.SecretStore
+	removed := 0
+	var walk func(value interface{}) error
+	walk = func(value interface{}) error {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			for key, item := range v {
+				secret, ok := item.(string)
+				if !ok || !utils.IsSecretKey(key) || secret == "" || strings.HasPrefix(secret, utils.SECRET_SCHEME) {
+					if err := walk(item); err != nil {
+						return err
+					}
+					continue
+				}
+				if store == nil {
+					var err error
+					if store, err = utils.OpenSecretStore(); err != nil {
+						return err
+					}
+				}
+				if ref, found := store.Reference(secret); found {
+					v[key] = ref
+				} else {
+					delete(v, key)
+					removed += 1
+				}
+			}
+		case []interface{}:
+			for _, item := range v {
+				if err := walk(item); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	if err := walk(data); err != nil {
+		return nil, err
+	}
+	if removed > 0 {
+		fmt.Printf("%d secret(s) not in the secret store were not exported, see xcat3 secret set.\n", removed)
+	}
+	return json.Marshal(data)
+}
+
 func ShowNodes(cmd *cobra.Command, args []string) {
 	var fields []string
 	if showOpts.fields != "" {
@@ -335,15 +728,20 @@ func ShowNodes(cmd *cobra.Command, args []string) {
 		fmt.Println("show command should accept node(s) as the argument.")
 		os.Exit(1)
 	}
-	names, err := utils.ToNodeArray(args[0])
+	names, err := _expand_noderange(args[0])
 	if err != nil {
 		fmt.Println(err)
+		os.Exit(1)
 	}
 	result, err := client.Show(names, fields)
 	if err != nil {
 		fmt.Println(err)
 		os.Exit(1)
 	}
+	if result, err = utils.MaskSecretsJson(result.([]byte)); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
 	utils.PrintJson(result)
 }
 
@@ -358,6 +756,7 @@ func ShowCommand() *cobra.Command {
 	}
 	cmd.Flags().StringVarP(&showOpts.fields, "fields", "i", "",
 		`Fields seperated by comma. Only these fields will be fetched from the server.`)
+	_add_selector_flag(cmd)
 	return cmd
 }
 
@@ -371,9 +770,10 @@ func DeleteNodes(cmd *cobra.Command, args []string) {
 		fmt.Println("Delete command should accept node(s) as the argument.")
 		os.Exit(1)
 	}
-	names, err := utils.ToNodeArray(args[0])
+	names, err := _expand_noderange(args[0])
 	if err != nil {
 		fmt.Println(err)
+		os.Exit(1)
 	}
 	result, err := client.Delete(names)
 	if err != nil {
@@ -391,6 +791,7 @@ func DeleteCommand() *cobra.Command {
 		Format: delete <node range>`,
 		Run: DeleteNodes,
 	}
+	_add_selector_flag(cmd)
 	return cmd
 }
 
@@ -404,24 +805,11 @@ func ImportNodes(cmd *cobra.Command, args []string) {
 		fmt.Println(err)
 		os.Exit(1)
 	}
-	var result map[string]interface{}
-	if len(data["nodes"].([]interface{})) < 3000 {
-		client, err := NewNodeClient()
-		if err != nil {
-			fmt.Println(err)
-			os.Exit(1)
-		}
-		result, err = client.Post("", data)
-		if err != nil {
-			fmt.Println(err)
-			os.Exit(1)
-		}
-
-	} else {
-		result = _parallel_create(data)
-
+	if err = _validate_import(data); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
 	}
-	_print_node_result(result)
+	_print_node_result(_bulk_create(data))
 }
 
 func ImportCommand() *cobra.Command {
@@ -445,15 +833,20 @@ func ExportNodes(cmd *cobra.Command, args []string) {
 		fmt.Println(err)
 		os.Exit(1)
 	}
-	names, err := utils.ToNodeArray(args[0])
+	names, err := _expand_noderange(args[0])
 	if err != nil {
 		fmt.Println(err)
+		os.Exit(1)
 	}
 	result, err := client.Show(names, exportFields)
 	if err != nil {
 		fmt.Println(err)
 		os.Exit(1)
 	}
+	if result, err = _export_secrets(result.([]byte)); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
 	utils.WriteJsonFile(exportOpts.filepath, result.([]byte))
 	if err != nil {
 		fmt.Println(err)
@@ -472,25 +865,47 @@ func ExportCommand() *cobra.Command {
 	}
 	cmd.Flags().StringVarP(&exportOpts.filepath, "output", "o", "",
 		`The output file stores nodes data in json format.`)
+	_add_selector_flag(cmd)
 	return cmd
 }
 
+// _update_patches turns key=val arguments into validated patches, with the
+// short field names like nics mapped to the attributes of the node.
+func _update_patches(args []string) ([]map[string]interface{}, error) {
+	patches, err := arg_array_to_patch(args)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range patches {
+		path := p["path"].(string)
+		key := strings.Split(path, "/")[1]
+		if _, ok := FIELD_MAP[key]; ok {
+			p["path"] = strings.Replace(path, key, FIELD_MAP[key], 1)
+		}
+	}
+	if err = NODE_SCHEMAS[CURRENT_SCHEMA_VERSION].ValidatePatches(patches); err != nil {
+		return nil, err
+	}
+	if err = utils.ResolveSecrets(patches); err != nil {
+		return nil, err
+	}
+	return patches, nil
+}
+
 func UpdateNodes(cmd *cobra.Command, args []string) {
 	if len(args) < 2 {
 		fmt.Println("show command should accept node(s) and attributes format like key=value as the arguments.")
 		os.Exit(1)
 	}
-	names, err := utils.ToNodeArray(args[0])
+	names, err := _expand_noderange(args[0])
 	if err != nil {
 		fmt.Println(err)
+		os.Exit(1)
 	}
-	patches := arg_array_to_patch(args[1:])
-	for _, p := range patches {
-		path := p["path"]
-		key := strings.Split(path, "/")[1]
-		if _, ok := FIELD_MAP[key]; ok {
-			p["path"] = strings.Replace(p["path"], key, FIELD_MAP[key], 1)
-		}
+	patches, err := _update_patches(args[1:])
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
 	}
 	data := make(map[string]interface{})
 	data["nodes"] = make([]interface{}, 0)
@@ -499,22 +914,7 @@ func UpdateNodes(cmd *cobra.Command, args []string) {
 		data["nodes"] = append(data["nodes"].([]interface{}), node)
 	}
 	data["patches"] = patches
-	var result map[string]interface{}
-	if len(data["nodes"].([]interface{})) < 3000 {
-		client, err := NewNodeClient()
-		if err != nil {
-			fmt.Println(err)
-			os.Exit(1)
-		}
-		result, err = client.Patch("", data)
-		if err != nil {
-			fmt.Println(err)
-			os.Exit(1)
-		}
-	} else {
-		result = _parallel_update(data)
-	}
-	_print_node_result(result)
+	_print_node_result(_bulk_update(data))
 }
 
 func UpdateCommand() *cobra.Command {
@@ -522,129 +922,458 @@ func UpdateCommand() *cobra.Command {
 		Use:   "update <node range> <key=val> [<key=val>]",
 		Short: "Update information about registered node(s).",
 		Long: `Update information about registered node(s).
-		update <node range> <key=val> [<key=val>]`,
+		update <node range> <key=val> [<key=val>]
+		Keys may be nested with dots like control.bmc_username=admin and typed like vlan:int=10.
+		An empty value like key= removes the attribute.`,
 		Run: UpdateNodes,
 	}
+	_add_selector_flag(cmd)
 	return cmd
 }
 
+// _print_boot_table prints the boot device of each node. The server returns
+// either the device name or the device with its persistence and boot mode.
+func _print_boot_table(result map[string]interface{}) {
+	nodes := utils.InterfaceToMap(result["nodes"])
+	names := make([]string, 0, len(nodes))
+	for name := range nodes {
+		names = append(names, name)
+	}
+	utils.SortNatural(names)
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "NODE\tDEVICE\tPERSISTENT\tMODE")
+	for _, name := range names {
+		device, persistent, mode := fmt.Sprint(nodes[name]), "-", "-"
+		if info, ok := nodes[name].(map[string]interface{}); ok {
+			device = fmt.Sprint(info["boot_device"])
+			if v, ok := info["persistent"]; ok && v != nil {
+				persistent = fmt.Sprint(v)
+			}
+			if v, ok := info["mode"]; ok && v != nil {
+				mode = fmt.Sprint(v)
+			}
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", name, device, persistent, mode)
+	}
+	w.Flush()
+}
+
 func BootDev(cmd *cobra.Command, args []string) {
 	if len(args) < 2 {
 		fmt.Println("bootdev command should accept node(s) and status/disk/net/cdrom as the arguments.")
 		os.Exit(1)
 	}
-	names, err := utils.ToNodeArray(args[0])
+	if exist, _ := utils.Contains(allowBootDev, args[1]); !exist {
+		fmt.Printf("Only allow %s\n", strings.Join(allowBootDev, " "))
+		os.Exit(1)
+	}
+	if bootDevOpts.uefi && bootDevOpts.legacy {
+		fmt.Println("--uefi and --legacy could not be used together.")
+		os.Exit(1)
+	}
+	if args[1] == "status" && (bootDevOpts.persistent || bootDevOpts.uefi || bootDevOpts.legacy) {
+		fmt.Println("--persistent, --uefi and --legacy only apply when setting the boot device.")
+		os.Exit(1)
+	}
+	names, err := _expand_noderange(args[0])
 	if err != nil {
 		fmt.Println(err)
+		os.Exit(1)
 	}
 	client, err := NewNodeClient()
 	if err != nil {
 		fmt.Println(err)
 		os.Exit(1)
 	}
-	var result interface{}
 	data := client.ToNodesMap(names)
 	if args[1] == "status" {
-		result, err = client.Get("boot_device", data)
+		result, err := client.Get("boot_device", data)
 		if err != nil {
 			fmt.Println(err)
 			os.Exit(1)
 		}
-	} else {
-		if exist, _ := utils.Contains(allowBootDev, args[1]); !exist {
-			if err != nil {
-				fmt.Printf("Only allow %s\n", strings.Join(allowBootDev, " "))
-				os.Exit(1)
-			}
-		}
-		result, err = client.Put("boot_device", args[1], data)
+		_print_boot_table(result.(map[string]interface{}))
+		return
 	}
-	_print_node_result(result.(map[string]interface{}))
-
+	mode := ""
+	if bootDevOpts.uefi {
+		mode = "uefi"
+	} else if bootDevOpts.legacy {
+		mode = "legacy"
+	}
+	result, err := client.SetBootDevice(args[1], bootDevOpts.persistent, mode, data)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	_print_node_result(result)
 }
 
 func BootDevCommand() *cobra.Command {
+	bootDevOpts = new(BootDevOptions)
 	cmd := &cobra.Command{
-		Use:   "bootdev <node range> net/disk/cdrom/status",
+		Use:   "bootdev <node range> net/disk/cdrom/status [--persistent] [--uefi/--legacy]",
 		Short: "Set/Get next boot device (net or disk or cdrom).",
 		Long: `Set/Get next boot device (net or disk or cdrom).
-		Format: bootdev <node range> net/disk/cdrom/status`,
+		Format: bootdev <node range> net/disk/cdrom/status [--persistent] [--uefi/--legacy]
+		The boot device applies to the next boot only unless --persistent is given. status shows
+		the device, the persistence and the boot mode of each node.`,
 		Run: BootDev,
 	}
+	cmd.Flags().BoolVarP(&bootDevOpts.persistent, "persistent", "p", false,
+		`Keep the boot device for all the following boots.`)
+	cmd.Flags().BoolVarP(&bootDevOpts.uefi, "uefi", "", false,
+		`Boot in UEFI mode.`)
+	cmd.Flags().BoolVarP(&bootDevOpts.legacy, "legacy", "", false,
+		`Boot in legacy BIOS mode.`)
+	_add_selector_flag(cmd)
 	return cmd
 }
 
+// _wait_power waits for the nodes the server accepted the power request for
+// to reach the target state. The nodes which were in the target state before
+// a boot or a cycle, according to before, must change state first: the time
+// of the last change reported by the server differs from the one before the
+// request, or a state other than the target is seen.
+func _wait_power(client *NodeClient, result map[string]interface{}, names []string, target string,
+	before *powerSnapshot) map[string]*NodeProgress {
+	accepted := make([]string, 0, len(names))
+	failed := make(map[string]string)
+	for k, v := range utils.InterfaceToMap(result["nodes"]) {
+		if _, ok := SUCCESS_RESULTS[fmt.Sprint(v)]; ok {
+			accepted = append(accepted, k)
+		} else {
+			failed[k] = fmt.Sprint(v)
+		}
+	}
+	utils.SortNatural(accepted)
+	cycling := make(map[string]bool)
+	if before != nil {
+		for name, state := range before.states {
+			cycling[name] = state == target
+		}
+	}
+	fetch := func(pending []string) (map[string]string, error) {
+		states, changes, err := _node_state_changes(client, "power", pending)
+		if err != nil {
+			return nil, err
+		}
+		for name, changed := range changes {
+			if cycling[name] && changed != before.changes[name] {
+				cycling[name] = false
+			}
+		}
+		return states, nil
+	}
+	check := func(name string, state string) string {
+		if cycling[name] {
+			// off or a transition state of the server
+			if state != target && state != "unknown" {
+				cycling[name] = false
+			}
+			return ""
+		}
+		if state == target {
+			return "done"
+		}
+		return ""
+	}
+	progress := _wait_nodes(accepted, fetch, check, powerOpts.timeout, powerOpts.interval, nil)
+	for name, state := range failed {
+		progress[name] = &NodeProgress{State: state, Result: "failed"}
+	}
+	for _, name := range names {
+		if _, ok := progress[name]; !ok {
+			progress[name] = &NodeProgress{State: "no response", Result: "failed"}
+		}
+	}
+	return progress
+}
+
+// powerSnapshot is the power state of the nodes before a power request, and
+// the time of its last change when the server reports it.
+type powerSnapshot struct {
+	states  map[string]string
+	changes map[string]string
+}
+
+// _power_before returns the power state of the nodes before a boot or a
+// cycle with --wait, and nil otherwise.
+func _power_before(client *NodeClient, action string, names []string, wait bool) (*powerSnapshot, error) {
+	if !wait || !POWER_CYCLE_ACTIONS[action] {
+		return nil, nil
+	}
+	states, changes, err := _node_state_changes(client, "power", names)
+	if err != nil {
+		return nil, err
+	}
+	return &powerSnapshot{states: states, changes: changes}, nil
+}
+
 func PowerNodes(cmd *cobra.Command, args []string) {
 	if len(args) < 2 {
-		fmt.Println("power command should accept node(s) and status/on/off/boot as the arguments.")
+		fmt.Printf("power command should accept node(s) and %s as the arguments.\n", strings.Join(allowPowerStatus, "/"))
 		os.Exit(1)
 	}
-	names, err := utils.ToNodeArray(args[0])
+	if exist, _ := utils.Contains(allowPowerStatus, args[1]); !exist {
+		fmt.Printf("Only allow %s\n", strings.Join(allowPowerStatus, " "))
+		os.Exit(1)
+	}
+	names, err := _expand_noderange(args[0])
 	if err != nil {
 		fmt.Println(err)
+		os.Exit(1)
 	}
 	client, err := NewNodeClient()
 	if err != nil {
 		fmt.Println(err)
 		os.Exit(1)
 	}
-	var result interface{}
-	data := client.ToNodesMap(names)
-	if args[1] == "status" {
-		result, err = client.Get("power", data)
+	target := POWER_TARGET_STATES[args[1]]
+	wait := powerOpts.wait && target != ""
+	if args[1] != "status" && powerOpts.waves.enabled() {
+		waves, err := _make_waves(names, &powerOpts.waves)
 		if err != nil {
 			fmt.Println(err)
 			os.Exit(1)
 		}
-	} else {
-		if exist, _ := utils.Contains(allowPowerStatus, args[1]); !exist {
+		// with --wait a wave is done when its node(s) reach the target state
+		_run_waves(waves, &powerOpts.waves, func(wave []string) (map[string]interface{}, error) {
+			before, err := _power_before(client, args[1], wave, wait)
 			if err != nil {
-				fmt.Printf("Only allow %s\n", strings.Join(allowPowerStatus, " "))
-				os.Exit(1)
+				return nil, err
 			}
-		}
+			result, err := client.Put("power", args[1], client.ToNodesMap(wave))
+			if err != nil || !wait {
+				return result, err
+			}
+			nodes := utils.InterfaceToMap(result["nodes"])
+			for name, p := range _wait_power(client, result, wave, target, before) {
+				if p.Result != "done" {
+					nodes[name] = p.Result + ": " + p.State
+				}
+			}
+			return map[string]interface{}{"nodes": nodes}, nil
+		})
+		return
+	}
+	before, err := _power_before(client, args[1], names, wait)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	var result interface{}
+	data := client.ToNodesMap(names)
+	if args[1] == "status" {
+		result, err = client.Get("power", data)
+	} else {
 		result, err = client.Put("power", args[1], data)
 	}
-	_print_node_result(result.(map[string]interface{}))
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if !wait {
+		_print_node_result(result.(map[string]interface{}))
+		return
+	}
+	progress := _wait_power(client, result.(map[string]interface{}), names, target, before)
+	if _print_progress_table(names, progress) > 0 {
+		os.Exit(1)
+	}
 }
 
 func PowerCommand() *cobra.Command {
+	powerOpts = new(PowerNodeOptions)
 	cmd := &cobra.Command{
-		Use:   "power <node range> status/on/off/boot",
+		Use:   "power <node range> status/on/off/boot/reset/softoff/cycle/nmi [--wait [--timeout 5m]]",
 		Short: "Power operation on/off/reset/status for nodes.",
-		Long:  `Power operation on/off/reset/status for nodes. Format: power <node range> status/on/off/boot`,
-		Run:   PowerNodes,
-	}
+		Long: `Power operation on/off/reset/status for nodes.
+		Format: power <node range> status/on/off/boot/reset/softoff/cycle/nmi [--wait [--timeout 5m]]
+		With --wait the command polls the power state until every node reaches the target state
+		and prints the time each node took. It exits with 1 if any node did not make it in time.
+		For boot and cycle the node(s) already on must change state before coming back, which the
+		time of the last power change tells when the server reports it.
+		With --wave-size or --max-per-rack the node(s) are handled in waves, like
+		power compute on --wave-size 100 --wave-interval 30s --max-failure-rate 0.1`,
+		Run: PowerNodes,
+	}
+	cmd.Flags().BoolVarP(&powerOpts.wait, "wait", "w", false,
+		`Wait until the node(s) reach the target power state.`)
+	cmd.Flags().DurationVarP(&powerOpts.timeout, "timeout", "t", 5*time.Minute,
+		`How long to wait for the node(s).`)
+	cmd.Flags().DurationVarP(&powerOpts.interval, "interval", "", 5*time.Second,
+		`How often to poll the power state.`)
+	_add_wave_flags(cmd, &powerOpts.waves)
+	_add_selector_flag(cmd)
 	return cmd
 }
 
+// _deploy_chain sets the next boot device to net and boots the nodes the
+// server accepted the deploy request for, as asked by --bootdev and --boot.
+// The result of the failed steps replaces the deploy result of the node.
+func _deploy_chain(client *NodeClient, result map[string]interface{}) (map[string]interface{}, error) {
+	nodes := utils.InterfaceToMap(result["nodes"])
+	steps := make([][]string, 0, 2)
+	if deployOpts.bootdev || deployOpts.boot {
+		steps = append(steps, []string{"boot_device", "net"})
+	}
+	if deployOpts.boot {
+		steps = append(steps, []string{"power", "boot"})
+	}
+	for _, step := range steps {
+		accepted := make([]string, 0, len(nodes))
+		for name, v := range nodes {
+			if _, ok := SUCCESS_RESULTS[fmt.Sprint(v)]; ok {
+				accepted = append(accepted, name)
+			}
+		}
+		if len(accepted) == 0 {
+			break
+		}
+		ret, err := client.Put(step[0], step[1], client.ToNodesMap(accepted))
+		if err != nil {
+			return nil, err
+		}
+		for name, v := range utils.InterfaceToMap(ret["nodes"]) {
+			if _, ok := SUCCESS_RESULTS[fmt.Sprint(v)]; !ok {
+				nodes[name] = fmt.Sprintf("%s %s: %v", step[0], step[1], v)
+			}
+		}
+	}
+	return map[string]interface{}{"nodes": nodes}, nil
+}
+
+// _wait_deploy polls the provision state of the nodes until they reach the
+// target state.
+func _wait_deploy(client *NodeClient, names []string) map[string]*NodeProgress {
+	fetch := func(pending []string) (map[string]string, error) {
+		nodes, err := _show_nodes(client, pending, []string{"state"})
+		if err != nil {
+			return nil, err
+		}
+		states := make(map[string]string, len(nodes))
+		for _, node := range nodes {
+			if state, ok := node["state"]; ok && state != nil {
+				states[node["name"].(string)] = fmt.Sprint(state)
+			}
+		}
+		return states, nil
+	}
+	check := func(name string, state string) string {
+		if state == deployOpts.target {
+			return "done"
+		}
+		if strings.Contains(state, "fail") || strings.Contains(state, "error") {
+			return "failed"
+		}
+		return ""
+	}
+	return _wait_nodes(names, fetch, check, deployOpts.timeout, deployOpts.interval, _live_table(names))
+}
+
 func DeployNodes(cmd *cobra.Command, args []string) {
-	if len(args) < 1 {
+	if len(args) < 1 && deployOpts.mapFile == "" {
 		fmt.Println("Please specified nodes")
 		os.Exit(1)
 	}
-	names, err := utils.ToNodeArray(args[0])
+	noderange := ""
+	if len(args) > 0 {
+		noderange = args[0]
+	}
+	names, plan, err := _deploy_plan(noderange)
 	if err != nil {
 		fmt.Println(err)
+		os.Exit(1)
+	}
+	targets, groups := _group_by_target(names, plan)
+	if !deployOpts.delete {
+		osimageClient, err := NewOsimageClient()
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		checked := make(map[string]bool)
+		for _, target := range targets {
+			if target.Osimage == "" || checked[target.Osimage] {
+				continue
+			}
+			if _, err = osimageClient.Show(target.Osimage, []string{"name"}, nil, true); err != nil {
+				fmt.Printf("Could not find osimage %s: %s\n", target.Osimage, err)
+				os.Exit(1)
+			}
+			checked[target.Osimage] = true
+		}
+	}
+	if len(targets) > 1 {
+		for _, target := range targets {
+			fmt.Printf("%s (%s): %d node(s)\n", target.Osimage, target.State, len(groups[target]))
+		}
+		fmt.Println()
 	}
 	client, err := NewNodeClient()
 	if err != nil {
 		fmt.Println(err)
 		os.Exit(1)
 	}
-	var result interface{}
-	data := client.ToNodesMap(names)
-	result, err = client.Deploy(deployOpts.osimage, deployOpts.state, deployOpts.delete, data)
-	_print_node_result(result.(map[string]interface{}))
+	waves, err := _make_waves(names, &deployOpts.waves)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	result := _run_waves(waves, &deployOpts.waves, func(wave []string) (map[string]interface{}, error) {
+		// one provision request for each osimage of the wave
+		waveTargets, waveGroups := _group_by_target(wave, plan)
+		result := map[string]interface{}{"nodes": make(map[string]interface{})}
+		for _, target := range waveTargets {
+			ret, err := client.Deploy(target.Osimage, target.State, deployOpts.delete, client.ToNodesMap(waveGroups[target]))
+			if err != nil {
+				return nil, err
+			}
+			utils.MergeMap(result["nodes"].(map[string]interface{}), utils.InterfaceToMap(ret["nodes"]))
+		}
+		if deployOpts.delete {
+			return result, nil
+		}
+		return _deploy_chain(client, result)
+	})
+	if !deployOpts.wait || deployOpts.delete {
+		return
+	}
+	accepted := make([]string, 0, len(names))
+	for name, v := range utils.InterfaceToMap(result["nodes"]) {
+		if _, ok := SUCCESS_RESULTS[fmt.Sprint(v)]; ok {
+			accepted = append(accepted, name)
+		}
+	}
+	utils.SortNatural(accepted)
+	fmt.Printf("\nWaiting for %d node(s) to be %s\n", len(accepted), deployOpts.target)
+	progress := _wait_deploy(client, accepted)
+	fmt.Println()
+	if _print_progress_table(accepted, progress) > 0 || len(accepted) < len(names) {
+		os.Exit(1)
+	}
 }
 
 func DeployCommand() *cobra.Command {
 	deployOpts = new(DeployNodeOptions)
 	cmd := &cobra.Command{
-		Use:   "deploy <node range> --osimage <osimage> [--state dhcp/nodeset] [-d]",
+		Use:   "deploy [<node range>] [--osimage <osimage>] [--map <file>] [--state dhcp/nodeset] [-d] [--boot] [--wait]",
 		Short: "Deploy node(s) into specified state.",
-		Long:  `Deploy node(s) into specified state. Format: deploy <node range> --osimage <osimage> [--state dhcp/nodeset] [-d]`,
-		Run:   DeployNodes,
+		Long: `Deploy node(s) into specified state. Format: deploy <node range> --osimage <osimage> [--state dhcp/nodeset] [-d]
+		--boot sets the next boot device to net and boots the node(s) once the deploy request is
+		accepted. With --wait the command then follows the provision state of the node(s) until they
+		are deployed, and exits with 1 if any node failed or did not make it in time, like
+		deploy compute --osimage rhels7.3 --boot --wait --timeout 45m
+		With --wave-size or --max-per-rack the node(s) are handled in waves.
+		Node(s) with different osimages are deployed at once with --map, where the YAML or JSON file
+		maps node ranges to osimages like
+		{"compute[01-40]": "rhels7.3", "gpu": {"osimage": "ubuntu16.04", "state": "dhcp"}}
+		or in YAML one 'compute[01-40]: rhels7.3' line per node range.
+		Without a node range the node(s) of the file are deployed. --osimage applies to the node(s)
+		missing from the file. Without it the osimage of each node is read from the node attribute
+		given by --attr. One request is sent per osimage.`,
+		Run: DeployNodes,
 	}
 	cmd.Flags().StringVarP(&deployOpts.state, "state", "", "nodeset",
 		`nodeset' or 'dhcp.`)
@@ -652,11 +1381,30 @@ func DeployCommand() *cobra.Command {
 		`osimage name`)
 	cmd.Flags().BoolVarP(&deployOpts.delete, "delete", "d", false,
 		`Recover from deploy state`)
+	cmd.Flags().StringVarP(&deployOpts.mapFile, "map", "", "",
+		`File mapping node ranges to osimage and state, see above.`)
+	cmd.Flags().StringVarP(&deployOpts.attr, "attr", "", OSIMAGE_NODE_ATTR,
+		`Node attribute holding the osimage of each node, like extra.provmethod.`)
+	cmd.Flags().BoolVarP(&deployOpts.bootdev, "bootdev", "", false,
+		`Set the next boot device of the node(s) to net after the deploy request.`)
+	cmd.Flags().BoolVarP(&deployOpts.boot, "boot", "", false,
+		`Set the next boot device to net and boot the node(s) after the deploy request.`)
+	cmd.Flags().BoolVarP(&deployOpts.wait, "wait", "w", false,
+		`Wait until the node(s) reach the target provision state.`)
+	cmd.Flags().StringVarP(&deployOpts.target, "target-state", "", "deployed",
+		`The provision state --wait expects.`)
+	cmd.Flags().DurationVarP(&deployOpts.timeout, "timeout", "t", 60*time.Minute,
+		`How long to wait for the node(s).`)
+	cmd.Flags().DurationVarP(&deployOpts.interval, "interval", "", 15*time.Second,
+		`How often to poll the provision state.`)
+	_add_wave_flags(cmd, &deployOpts.waves)
+	_add_selector_flag(cmd)
 	return cmd
 }
 
 func init() {
 	RootCmd.AddCommand(CreateCommand())
+	RootCmd.AddCommand(CloneCommand())
 	RootCmd.AddCommand(ListCommand())
 	RootCmd.AddCommand(ShowCommand())
 	RootCmd.AddCommand(DeleteCommand())
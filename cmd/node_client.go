@@ -35,6 +35,25 @@ func (client *NodeClient) ToNodesMap(names []string) map[string]interface{} {
 	return data
 }
 
+// List returns the nodes of the service. The params are passed as query
+// parameters so that the server may filter the nodes.
+func (client *NodeClient) List(fields []string, params url.Values) ([]interface{}, error) {
+	if params == nil {
+		params = url.Values{}
+	}
+	if len(fields) > 0 {
+		if exist, _ := utils.Contains(fields, "name"); !exist {
+			fields = append(fields, "name")
+		}
+		params.Set("fields", strings.Join(fields, ","))
+	}
+	result, err := client.Sess.Get(client.Resource, &params, nil, false)
+	if err != nil {
+		return nil, err
+	}
+	return utils.InterfaceToSlice(utils.InterfaceToMap(result)["nodes"]), nil
+}
+
 func (client *NodeClient) Show(names []string, fields []string) (interface{}, error) {
 	params := url.Values{}
 	if len(fields) > 0 {
@@ -58,6 +77,30 @@ func (client *NodeClient) Show(names []string, fields []string) (interface{}, er
 	return result, nil
 }
 
+// Hardware gets hardware information of the nodes from a resource like
+// inventory, vitals or eventlog.
+func (client *NodeClient) Hardware(resource string, params url.Values, data interface{}) (map[string]interface{}, error) {
+	result, err := client.Sess.Get(client.Resource+"/"+resource, &params, data, false)
+	if err != nil {
+		return nil, err
+	}
+	ret := utils.InterfaceToMap(result)
+	return ret, nil
+}
+
+// Console returns the url of the console proxy of the node.
+func (client *NodeClient) Console(name string) (string, error) {
+	result, err := client.Sess.Get(client.Resource+"/"+name+"/console", nil, nil, false)
+	if err != nil {
+		return "", err
+	}
+	consoleUrl, _ := utils.InterfaceToMap(result)["url"].(string)
+	if consoleUrl == "" {
+		return "", errors.New("The server did not return the console url of " + name + ".")
+	}
+	return utils.ConsoleURL(consoleUrl)
+}
+
 func (client *NodeClient) Delete(names []string) (map[string]interface{}, error) {
 	data := client.ToNodesMap(names)
 	result, err := client.Sess.Delete(client.Resource, nil, data, false)
@@ -88,6 +131,38 @@ func (client *NodeClient) Deploy(osimage string, state string, destroy bool, dat
 	return ret, nil
 }
 
+// SetBootDevice sets the next boot device of the nodes. The device is kept
+// for the following boots when persistent is set, and mode is uefi or legacy
+// when not empty.
+func (client *NodeClient) SetBootDevice(device string, persistent bool, mode string, data interface{}) (map[string]interface{}, error) {
+	params := url.Values{}
+	params.Set("target", device)
+	if persistent {
+		params.Set("persistent", "true")
+	}
+	if mode != "" {
+		params.Set("mode", mode)
+	}
+	result, err := client.Sess.Put(client.Resource+"/boot_device", &params, data, false)
+	if err != nil {
+		return nil, err
+	}
+	ret := utils.InterfaceToMap(result)
+	return ret, nil
+}
+
+// SetBmcPassword changes the password of the BMC of the nodes, given as
+// {"nodes": [{"name": <node>, "password": <password>}]}. The node attributes
+// are not changed.
+func (client *NodeClient) SetBmcPassword(data interface{}) (map[string]interface{}, error) {
+	result, err := client.Sess.Put(client.Resource+"/bmc_password", nil, data, false)
+	if err != nil {
+		return nil, err
+	}
+	ret := utils.InterfaceToMap(result)
+	return ret, nil
+}
+
 func (client *NodeClient) Post(url string, data interface{}) (map[string]interface{}, error) {
 	if url != "" {
 		url = client.Resource + "/" + url
@@ -13,8 +13,20 @@ type ShowOsimageOptions struct {
 	fields string
 }
 
+type DeleteOsimageOptions struct {
+	force bool
+	attr  string
+}
+
+type CreateOsimageOptions struct {
+	iso       string
+	chunkSize int64
+}
+
 var (
-	showOsimageOpts *ShowOsimageOptions
+	showOsimageOpts   *ShowOsimageOptions
+	createOsimageOpts *CreateOsimageOptions
+	deleteOsimageOpts *DeleteOsimageOptions
 )
 
 func ListOsimage(cmd *cobra.Command, args []string) {
@@ -87,11 +99,96 @@ func ShowOsimageCommand() *cobra.Command {
 	return cmd
 }
 
+func CreateOsimage(cmd *cobra.Command, args []string) {
+	if len(args) < 1 {
+		fmt.Println("Please specify the name of osimage")
+		os.Exit(1)
+	}
+	attrs, err := utils.KeyValueArrayToMap(args[1:])
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	attrs["name"] = args[0]
+	client, err := NewOsimageClient()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if createOsimageOpts.iso != "" {
+		if createOsimageOpts.chunkSize <= 0 {
+			fmt.Println("The chunk size should be a positive number of MiB.")
+			os.Exit(1)
+		}
+		distro, arch, err := _detect_distro(createOsimageOpts.iso)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		if _, ok := attrs["distro"]; !ok && distro != "" {
+			attrs["distro"] = distro
+		}
+		if _, ok := attrs["arch"]; !ok && arch != "" {
+			attrs["arch"] = arch
+		}
+		for _, key := range []string{"distro", "arch"} {
+			if _, ok := attrs[key]; !ok {
+				fmt.Printf("Could not detect the %s of %s, please specify %s=<%s>.\n", key, createOsimageOpts.iso, key, key)
+				os.Exit(1)
+			}
+		}
+		fmt.Printf("%s: distro %v arch %v\n", createOsimageOpts.iso, attrs["distro"], attrs["arch"])
+		sum, err := _upload_iso(client, createOsimageOpts.iso, createOsimageOpts.chunkSize<<20)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		attrs["upload"] = sum
+		attrs["checksum"] = "sha256:" + sum
+	}
+	result, err := client.Post("", attrs, true)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	utils.PrintJson(result)
+}
+
+func CreateOsimageCommand() *cobra.Command {
+	createOsimageOpts = new(CreateOsimageOptions)
+	cmd := &cobra.Command{
+		Use:   "create <osimage name> [--iso <path>] [<key=val>]",
+		Short: "Register osimage into xCAT3 service.",
+		Long: `Register osimage into xCAT3 service. Format: create <osimage name> [--iso <path>] [<key=val>]
+		With --iso the ISO is uploaded to the server in chunks, and the distro and the arch are read
+		from the .treeinfo or the .disk/info of the ISO unless given like distro=rhels7.3 arch=x86_64.
+		An interrupted upload resumes when the command is run again.`,
+		Run: CreateOsimage,
+	}
+	cmd.Flags().StringVarP(&createOsimageOpts.iso, "iso", "", "",
+		`The ISO of the distro to upload.`)
+	cmd.Flags().Int64VarP(&createOsimageOpts.chunkSize, "chunk-size", "", 64,
+		`Size of the uploaded chunks in MiB.`)
+	return cmd
+}
+
 func DeleteOsimage(cmd *cobra.Command, args []string) {
 	if len(args) != 1 {
 		fmt.Println("Please specify the uuid of osimage to delete")
 		os.Exit(1)
 	}
+	if !deleteOsimageOpts.force {
+		usage, err := _osimage_usage(deleteOsimageOpts.attr, args[0])
+		if err != nil {
+			fmt.Printf("Could not check the nodes referencing osimage %s: %s\n", args[0], err)
+			os.Exit(1)
+		}
+		if names := usage[args[0]]; len(names) > 0 {
+			fmt.Printf("Osimage %s is referenced by %d node(s): %s\n", args[0], len(names), strings.Join(names, ","))
+			fmt.Println("Please redeploy the node(s) with another osimage or specify --force")
+			os.Exit(1)
+		}
+	}
 
 	client, err := NewOsimageClient()
 	if err != nil {
@@ -107,12 +204,18 @@ func DeleteOsimage(cmd *cobra.Command, args []string) {
 }
 
 func DeleteOsimageCommand() *cobra.Command {
+	deleteOsimageOpts = new(DeleteOsimageOptions)
 	cmd := &cobra.Command{
-		Use:   "delete <osimage name>",
+		Use:   "delete <osimage name> [--force]",
 		Short: "Unregister osimage from xCAT3 service.",
-		Long:  `Unregister osimage from xCAT3 service. Format: delete <osimage name>`,
-		Run:   DeleteOsimage,
+		Long: `Unregister osimage from xCAT3 service. Format: delete <osimage name> [--force]
+		The osimage is not deleted while node(s) reference it, see osimage usage, unless --force is given.`,
+		Run: DeleteOsimage,
 	}
+	cmd.Flags().BoolVarP(&deleteOsimageOpts.force, "force", "f", false,
+		`Delete the osimage even if node(s) reference it.`)
+	cmd.Flags().StringVarP(&deleteOsimageOpts.attr, "attr", "", OSIMAGE_NODE_ATTR,
+		`Node attribute holding the osimage of the node.`)
 	return cmd
 }
 
@@ -121,7 +224,11 @@ func UpdateOsimage(cmd *cobra.Command, args []string) {
 		fmt.Println("Please specify the name of osimage and attribute in key=val format to update")
 		os.Exit(1)
 	}
-	patches := arg_array_to_patch(args[1:])
+	patches, err := arg_array_to_patch(args[1:])
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
 	client, err := NewOsimageClient()
 	if err != nil {
 		fmt.Println(err)
@@ -159,6 +266,12 @@ func init() {
 	OsimageCmd := OsimageCommand()
 	OsimageCmd.AddCommand(ListOsimageCommand())
 	OsimageCmd.AddCommand(ShowOsimageCommand())
+	OsimageCmd.AddCommand(CreateOsimageCommand())
+	OsimageCmd.AddCommand(CloneOsimageCommand())
+	OsimageCmd.AddCommand(AttachOsimageCommand())
+	OsimageCmd.AddCommand(DetachOsimageCommand())
+	OsimageCmd.AddCommand(RenderOsimageCommand())
+	OsimageCmd.AddCommand(UsageOsimageCommand())
 	OsimageCmd.AddCommand(DeleteOsimageCommand())
 	OsimageCmd.AddCommand(UpdateOsimageCommand())
 	RootCmd.AddCommand(OsimageCmd)
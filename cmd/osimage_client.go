@@ -1,7 +1,11 @@
 package cmd
 
 import (
+	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
 
 	"github.com/chenglch/golang-xcat3client/utils"
@@ -20,3 +24,81 @@ func NewOsimageClient() (*OsimageClient, error) {
 	service := OsimageClient{client}
 	return &service, nil
 }
+
+// UploadStatus returns the number of bytes the server holds for the upload,
+// so that an interrupted upload resumes from there, and the sha256 of the
+// whole file once the upload is complete.
+func (client *OsimageClient) UploadStatus(id string) (int64, string, error) {
+	req, err := http.NewRequest("GET", client.Resource+"/uploads/"+id, nil)
+	if err != nil {
+		return 0, "", err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := client.Sess.Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return 0, "", nil
+	}
+	if err = utils.CheckHTTPResponseStatusCode(resp); err != nil {
+		return 0, "", err
+	}
+	var ret struct {
+		Offset int64  `json:"offset"`
+		Sha256 string `json:"sha256"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&ret); err != nil {
+		return 0, "", err
+	}
+	return ret.Offset, ret.Sha256, nil
+}
+
+// UploadChunk streams the bytes of the file from offset to the upload. The
+// server returns the new offset, and the sha256 of the whole file once the
+// last chunk is received.
+func (client *OsimageClient) UploadChunk(id string, file io.ReaderAt, offset int64, size int64, total int64) (int64, string, error) {
+	body := io.NewSectionReader(file, offset, size)
+	req, err := http.NewRequest("PUT", client.Resource+"/uploads/"+id, body)
+	if err != nil {
+		return 0, "", err
+	}
+	req.ContentLength = size
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, offset+size-1, total))
+	resp, err := client.Sess.Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+	if err = utils.CheckHTTPResponseStatusCode(resp); err != nil {
+		return 0, "", err
+	}
+	var ret struct {
+		Offset int64  `json:"offset"`
+		Sha256 string `json:"sha256"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&ret); err != nil {
+		return 0, "", err
+	}
+	return ret.Offset, ret.Sha256, nil
+}
+
+// Download returns the content of a complete upload.
+func (client *OsimageClient) Download(id string) ([]byte, error) {
+	req, err := http.NewRequest("GET", client.Resource+"/uploads/"+id+"/content", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Sess.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if err = utils.CheckHTTPResponseStatusCode(resp); err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(resp.Body)
+}
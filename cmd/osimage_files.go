@@ -0,0 +1,348 @@
+package cmd
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/chenglch/golang-xcat3client/utils"
+	"github.com/spf13/cobra"
+)
+
+// The install template, the post install scripts and the extra packages of
+// an osimage are uploaded as files, and their references are stored in the
+// template, scripts and packages attributes, so that osimage show lists them
+// with the rest of the image.
+
+type AttachOsimageOptions struct {
+	template string
+	kind     string
+	scripts  []string
+	packages string
+}
+
+type DetachOsimageOptions struct {
+	template bool
+	scripts  []string
+	packages bool
+}
+
+var (
+	cloneOsimageOpts  *AttachOsimageOptions
+	attachOsimageOpts *AttachOsimageOptions
+	detachOsimageOpts *DetachOsimageOptions
+
+	TEMPLATE_KINDS = []string{"kickstart", "preseed", "autoinstall"}
+	// OSIMAGE_STRIP_FIELDS are not copied by osimage clone.
+	OSIMAGE_STRIP_FIELDS = []string{"id", "uuid", "created_at", "updated_at"}
+)
+
+func _add_attach_flags(cmd *cobra.Command, opts *AttachOsimageOptions) {
+	cmd.Flags().StringVarP(&opts.template, "template", "", "",
+		`Kickstart, preseed or autoinstall template file used to install the node(s).`)
+	cmd.Flags().StringVarP(&opts.kind, "kind", "", "",
+		`Kind of the template, kickstart, preseed or autoinstall. Guessed from the file by default.`)
+	cmd.Flags().StringSliceVarP(&opts.scripts, "script", "", nil,
+		`Post install script file, may be repeated. The scripts run in the order of their names.`)
+	cmd.Flags().StringVarP(&opts.packages, "packages", "", "",
+		`File listing the extra packages to install, one or more per line.`)
+}
+
+// _template_kind guesses the kind of the template from the name of the file
+// or from its content.
+func _template_kind(path string, content string) string {
+	switch ext := strings.ToLower(filepath.Ext(path)); {
+	case ext == ".ks":
+		return "kickstart"
+	case ext == ".seed" || ext == ".preseed":
+		return "preseed"
+	case ext == ".yaml" || ext == ".yml" || filepath.Base(path) == "user-data":
+		return "autoinstall"
+	}
+	switch {
+	case strings.Contains(content, "autoinstall:"):
+		return "autoinstall"
+	case strings.Contains(content, "d-i "):
+		return "preseed"
+	case strings.Contains(content, "%packages"):
+		return "kickstart"
+	}
+	return ""
+}
+
+// _upload_attachment uploads the file like the ISO of osimage create, named
+// after its sha256, and returns the reference stored in the osimage. A file
+// the server already holds is not sent again.
+func _upload_attachment(client *OsimageClient, path string) (map[string]interface{}, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	total := int64(len(content))
+	if total == 0 {
+		return nil, fmt.Errorf("File %s is empty.", path)
+	}
+	hash := sha256.Sum256(content)
+	sum := hex.EncodeToString(hash[:])
+	offset, remote, err := client.UploadStatus(sum)
+	if err != nil {
+		return nil, err
+	}
+	if offset < total {
+		if _, remote, err = client.UploadChunk(sum, bytes.NewReader(content), offset, total-offset, total); err != nil {
+			return nil, err
+		}
+	}
+	if remote != sum {
+		return nil, fmt.Errorf("Checksum mismatch, the server received %s but %s is %s.", remote, path, sum)
+	}
+	return map[string]interface{}{"name": filepath.Base(path), "upload": sum}, nil
+}
+
+// _apply_attachments uploads the files given by the options, sets their
+// references into the osimage attributes and returns the names of the
+// changed attributes.
+func _apply_attachments(client *OsimageClient, image map[string]interface{}, opts *AttachOsimageOptions) ([]string, error) {
+	changed := make([]string, 0)
+	if opts.template != "" {
+		content, err := ioutil.ReadFile(opts.template)
+		if err != nil {
+			return nil, err
+		}
+		kind := opts.kind
+		if kind == "" {
+			if kind = _template_kind(opts.template, string(content)); kind == "" {
+				return nil, fmt.Errorf("Could not guess the kind of template %s, please specify --kind.", opts.template)
+			}
+		}
+		if exist, _ := utils.Contains(TEMPLATE_KINDS, kind); !exist {
+			return nil, fmt.Errorf("Invalid template kind %s, expected one of %s.", kind, strings.Join(TEMPLATE_KINDS, " "))
+		}
+		ref, err := _upload_attachment(client, opts.template)
+		if err != nil {
+			return nil, err
+		}
+		ref["kind"] = kind
+		image["template"] = ref
+		changed = append(changed, "template")
+	} else if opts.kind != "" {
+		return nil, fmt.Errorf("--kind applies to --template.")
+	}
+	if len(opts.scripts) > 0 {
+		scripts := utils.InterfaceToMap(image["scripts"])
+		for _, path := range opts.scripts {
+			ref, err := _upload_attachment(client, path)
+			if err != nil {
+				return nil, err
+			}
+			scripts[filepath.Base(path)] = ref
+		}
+		image["scripts"] = scripts
+		changed = append(changed, "scripts")
+	}
+	if opts.packages != "" {
+		ref, err := _upload_attachment(client, opts.packages)
+		if err != nil {
+			return nil, err
+		}
+		image["packages"] = ref
+		changed = append(changed, "packages")
+	}
+	return changed, nil
+}
+
+func _fetch_osimage(client *OsimageClient, name string) (map[string]interface{}, error) {
+	result, err := client.Show(name, nil, nil, true)
+	if err != nil {
+		return nil, fmt.Errorf("Could not find osimage %s: %s", name, err)
+	}
+	var image map[string]interface{}
+	if err = json.Unmarshal(result.([]byte), &image); err != nil {
+		return nil, err
+	}
+	return image, nil
+}
+
+func CloneOsimage(cmd *cobra.Command, args []string) {
+	if len(args) < 2 {
+		fmt.Println("Please specify the source osimage and the name of the new osimage")
+		os.Exit(1)
+	}
+	overrides, err := utils.KeyValueArrayToMap(args[2:])
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	client, err := NewOsimageClient()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	image, err := _fetch_osimage(client, args[0])
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	for _, field := range OSIMAGE_STRIP_FIELDS {
+		delete(image, field)
+	}
+	utils.DeepMergeMap(image, overrides)
+	image["name"] = args[1]
+	if _, err = _apply_attachments(client, image, cloneOsimageOpts); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	result, err := client.Post("", image, true)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	utils.PrintJson(result)
+}
+
+func CloneOsimageCommand() *cobra.Command {
+	cloneOsimageOpts = new(AttachOsimageOptions)
+	cmd := &cobra.Command{
+		Use:   "clone <source osimage> <new osimage> [<key=val>] [--template <file>] [--script <file>] [--packages <file>]",
+		Short: "Create an osimage from an existing one.",
+		Long: `Create an osimage from an existing one, like a variant for the gpu node(s). Format:
+		clone <source osimage> <new osimage> [<key=val>] [--template <file>] [--script <file>] [--packages <file>]
+		The key=val arguments and the files override the ones of the source osimage, like
+		clone rhels7.3 rhels7.3-gpu --packages gpu.list --script 10-cuda.sh`,
+		Run: CloneOsimage,
+	}
+	_add_attach_flags(cmd, cloneOsimageOpts)
+	return cmd
+}
+
+func AttachOsimage(cmd *cobra.Command, args []string) {
+	if len(args) != 1 {
+		fmt.Println("Please specify the name of osimage")
+		os.Exit(1)
+	}
+	client, err := NewOsimageClient()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	image, err := _fetch_osimage(client, args[0])
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	changed, err := _apply_attachments(client, image, attachOsimageOpts)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if len(changed) == 0 {
+		fmt.Println("Please specify --template, --script or --packages")
+		os.Exit(1)
+	}
+	patches := make([]map[string]interface{}, 0, len(changed))
+	for _, key := range changed {
+		patches = append(patches, map[string]interface{}{"op": "add", "path": "/" + key, "value": image[key]})
+	}
+	result, err := client.Patch(args[0], patches, true)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	utils.PrintJson(result)
+}
+
+func AttachOsimageCommand() *cobra.Command {
+	attachOsimageOpts = new(AttachOsimageOptions)
+	cmd := &cobra.Command{
+		Use:   "attach <osimage name> [--template <file>] [--script <file>] [--packages <file>]",
+		Short: "Attach install template, post install scripts or extra packages to osimage.",
+		Long: `Attach install template, post install scripts or extra packages to osimage.
+		Format: attach <osimage name> [--template <file> [--kind kickstart/preseed/autoinstall]] [--script <file>] [--packages <file>]
+		The template and the package list replace the current ones, and a script replaces the one
+		with the same file name.`,
+		Run: AttachOsimage,
+	}
+	_add_attach_flags(cmd, attachOsimageOpts)
+	return cmd
+}
+
+func DetachOsimage(cmd *cobra.Command, args []string) {
+	if len(args) != 1 {
+		fmt.Println("Please specify the name of osimage")
+		os.Exit(1)
+	}
+	client, err := NewOsimageClient()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	image, err := _fetch_osimage(client, args[0])
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	patches := make([]map[string]interface{}, 0)
+	removed := make([]string, 0, 2)
+	if detachOsimageOpts.template {
+		removed = append(removed, "template")
+	}
+	if detachOsimageOpts.packages {
+		removed = append(removed, "packages")
+	}
+	for _, key := range removed {
+		if _, ok := image[key]; !ok {
+			fmt.Printf("Osimage %s does not have %s\n", args[0], key)
+			os.Exit(1)
+		}
+		patches = append(patches, map[string]interface{}{"op": "remove", "path": "/" + key})
+	}
+	if len(detachOsimageOpts.scripts) > 0 {
+		scripts := utils.InterfaceToMap(image["scripts"])
+		for _, name := range detachOsimageOpts.scripts {
+			if _, ok := scripts[name]; !ok {
+				fmt.Printf("Osimage %s does not have script %s\n", args[0], name)
+				os.Exit(1)
+			}
+			delete(scripts, name)
+		}
+		if len(scripts) == 0 {
+			patches = append(patches, map[string]interface{}{"op": "remove", "path": "/scripts"})
+		} else {
+			patches = append(patches, map[string]interface{}{"op": "add", "path": "/scripts", "value": scripts})
+		}
+	}
+	if len(patches) == 0 {
+		fmt.Println("Please specify --template, --script or --packages")
+		os.Exit(1)
+	}
+	result, err := client.Patch(args[0], patches, true)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	utils.PrintJson(result)
+}
+
+func DetachOsimageCommand() *cobra.Command {
+	detachOsimageOpts = new(DetachOsimageOptions)
+	cmd := &cobra.Command{
+		Use:   "detach <osimage name> [--template] [--script <name>] [--packages]",
+		Short: "Remove install template, post install scripts or extra packages from osimage.",
+		Long: `Remove install template, post install scripts or extra packages from osimage.
+		Format: detach <osimage name> [--template] [--script <name>] [--packages]`,
+		Run: DetachOsimage,
+	}
+	cmd.Flags().BoolVarP(&detachOsimageOpts.template, "template", "", false,
+		`Remove the install template.`)
+	cmd.Flags().StringSliceVarP(&detachOsimageOpts.scripts, "script", "", nil,
+		`Remove the post install script with this name, may be repeated.`)
+	cmd.Flags().BoolVarP(&detachOsimageOpts.packages, "packages", "", false,
+		`Remove the extra packages.`)
+	return cmd
+}
@@ -0,0 +1,246 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"text/template"
+
+	"github.com/chenglch/golang-xcat3client/utils"
+	"github.com/spf13/cobra"
+)
+
+type RenderOsimageOptions struct {
+	output string
+}
+
+var (
+	renderOsimageOpts *RenderOsimageOptions
+	// TEMPLATE_FUNCS are the functions available to the install templates
+	// besides the text/template builtins.
+	TEMPLATE_FUNCS = template.FuncMap{
+		"join":  strings.Join,
+		"upper": strings.ToUpper,
+		"lower": strings.ToLower,
+		// get reads an optional attribute by its dotted path, as a missing
+		// key is an error otherwise
+		"get": func(m map[string]interface{}, path string) interface{} {
+			value, _ := utils.Lookup(m, strings.Split(path, "."))
+			return value
+		},
+		"default": func(value interface{}, given interface{}) interface{} {
+			if given == nil || given == "" {
+				return value
+			}
+			return given
+		},
+	}
+)
+
+// RenderData is given to the install template. Each nic holds its network
+// under the network key when the ip of the nic is in one of the networks.
+type RenderData struct {
+	Node    map[string]interface{}
+	Nics    []map[string]interface{}
+	Network map[string]interface{}
+	Passwd  map[string]map[string]interface{}
+	Osimage map[string]interface{}
+}
+
+func _fetch_node(name string) (map[string]interface{}, error) {
+	client, err := NewNodeClient()
+	if err != nil {
+		return nil, err
+	}
+	result, err := client.Show([]string{name}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Could not find node %s: %s", name, err)
+	}
+	var node map[string]interface{}
+	if err = json.Unmarshal(result.([]byte), &node); err != nil {
+		return nil, err
+	}
+	return node, nil
+}
+
+// _list_records returns the records of a resource listing, like the
+// networks of {"networks": [...]}.
+func _list_records(client *utils.XCAT3Client, key string) ([]map[string]interface{}, error) {
+	ret, err := client.Get("", nil)
+	if err != nil {
+		return nil, err
+	}
+	records := make([]map[string]interface{}, 0)
+	for _, value := range utils.InterfaceToSlice(utils.InterfaceToMap(ret)[key]) {
+		records = append(records, utils.InterfaceToMap(value))
+	}
+	return records, nil
+}
+
+// _nic_network returns the network whose subnet holds the ip.
+func _nic_network(ip string, networks []map[string]interface{}) map[string]interface{} {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return nil
+	}
+	for _, network := range networks {
+		subnet, _ := network["subnet"].(string)
+		netmask, _ := network["netmask"].(string)
+		if _, ipnet, err := net.ParseCIDR(subnet); err == nil {
+			if ipnet.Contains(addr) {
+				return network
+			}
+			continue
+		}
+		mask := net.ParseIP(netmask)
+		base := net.ParseIP(subnet)
+		if mask == nil || base == nil {
+			continue
+		}
+		if mask4 := mask.To4(); mask4 != nil {
+			mask = mask4
+		}
+		if addr.Mask(net.IPMask(mask)).Equal(base.Mask(net.IPMask(mask))) {
+			return network
+		}
+	}
+	return nil
+}
+
+func _render_data(osimage map[string]interface{}, nodeName string) (*RenderData, error) {
+	node, err := _fetch_node(nodeName)
+	if err != nil {
+		return nil, err
+	}
+	networkClient, err := NewNetworkClient()
+	if err != nil {
+		return nil, err
+	}
+	networks, err := _list_records(&networkClient.XCAT3Client, "networks")
+	if err != nil {
+		return nil, err
+	}
+	passwdClient, err := NewPasswdClient()
+	if err != nil {
+		return nil, err
+	}
+	passwds, err := _list_records(&passwdClient.XCAT3Client, "passwds")
+	if err != nil {
+		return nil, err
+	}
+	data := &RenderData{Node: node, Nics: make([]map[string]interface{}, 0),
+		Passwd: make(map[string]map[string]interface{}), Osimage: osimage}
+	for _, passwd := range passwds {
+		if key, ok := passwd["key"].(string); ok {
+			data.Passwd[key] = passwd
+		}
+	}
+	if nics_info, ok := node["nics_info"].(map[string]interface{}); ok {
+		for _, value := range utils.InterfaceToSlice(nics_info["nics"]) {
+			nic := utils.InterfaceToMap(value)
+			ip, _ := nic["ip"].(string)
+			if network := _nic_network(ip, networks); network != nil {
+				nic["network"] = network
+				if data.Network == nil {
+					// the network of the first nic is the install network
+					data.Network = network
+				}
+			}
+			data.Nics = append(data.Nics, nic)
+		}
+	}
+	return data, nil
+}
+
+// _render_template downloads the install template of the osimage and fills
+// it. Missing keys are errors, so that a typo in the template fails before
+// the node boots.
+func _render_template(client *OsimageClient, data *RenderData) ([]byte, string, error) {
+	attached := utils.InterfaceToMap(data.Osimage["template"])
+	upload, ok := attached["upload"].(string)
+	if !ok {
+		return nil, "", fmt.Errorf("Osimage %s does not have a template, see osimage attach.", data.Osimage["name"])
+	}
+	content, err := client.Download(upload)
+	if err != nil {
+		return nil, "", err
+	}
+	name, _ := attached["name"].(string)
+	kind, _ := attached["kind"].(string)
+	tmpl, err := template.New(name).Funcs(TEMPLATE_FUNCS).Option("missingkey=error").Parse(string(content))
+	if err != nil {
+		return nil, "", err
+	}
+	var buf bytes.Buffer
+	if err = tmpl.Execute(&buf, data); err != nil {
+		return nil, "", err
+	}
+	return buf.Bytes(), kind, nil
+}
+
+func RenderOsimage(cmd *cobra.Command, args []string) {
+	if len(args) != 2 {
+		fmt.Println("Please specify the name of osimage and the node")
+		os.Exit(1)
+	}
+	client, err := NewOsimageClient()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	osimage, err := _fetch_osimage(client, args[0])
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	data, err := _render_data(osimage, args[1])
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	result, kind, err := _render_template(client, data)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	output := renderOsimageOpts.output
+	if output == "-" {
+		os.Stdout.Write(result)
+		return
+	}
+	if output == "" {
+		output = args[1] + "." + kind
+	}
+	if err = ioutil.WriteFile(output, result, 0600); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	fmt.Printf("%s: rendered to %s\n", args[1], output)
+}
+
+func RenderOsimageCommand() *cobra.Command {
+	renderOsimageOpts = new(RenderOsimageOptions)
+	cmd := &cobra.Command{
+		Use:   "render <osimage name> <node> [-o <file>]",
+		Short: "Render the install template of osimage for a node locally.",
+		Long: `Render the install template of osimage for a node locally, to check it before deploy.
+		Format: render <osimage name> <node> [-o <file>]
+		The template is a Go text/template, where .Node holds the node attributes, .Nics the nics of
+		the node with the network of each nic under .network, .Network the network of the first nic,
+		.Passwd the passwd entries by key and .Osimage the osimage, like
+		  network --bootproto=static --ip={{(index .Nics 0).ip}} --gateway={{.Network.gateway}}
+		  rootpw --iscrypted {{.Passwd.system.password}}
+		A missing attribute is an error, get reads an optional one, like
+		  lang {{default "en_US.UTF-8" (get .Node "extra.lang")}}
+		The functions join, upper and lower are also available.
+		The result is written to <node>.<kind> by default, or to stdout with -o -.`,
+		Run: RenderOsimage,
+	}
+	cmd.Flags().StringVarP(&renderOsimageOpts.output, "output", "o", "",
+		`File to write the result to, - for stdout.`)
+	return cmd
+}
@@ -0,0 +1,241 @@
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/chenglch/golang-xcat3client/utils"
+)
+
+var (
+	// DISTRO_NAMES maps the product names found in the ISO to the short
+	// distro names of xCAT, which are followed by the version like rhels7.3.
+	DISTRO_NAMES = []struct {
+		prefix string
+		short  string
+	}{
+		{"red hat enterprise linux", "rhels"},
+		{"centos", "centos"},
+		{"fedora", "fedora"},
+		{"rocky", "rocky"},
+		{"almalinux", "alma"},
+		{"suse linux enterprise server", "sles"},
+		{"ubuntu", "ubuntu"},
+		{"debian", "debian"},
+	}
+	// ARCH_NAMES maps the debian arch names to the ones of xCAT.
+	ARCH_NAMES = map[string]string{"amd64": "x86_64", "ppc64el": "ppc64le", "arm64": "aarch64",
+		"x86_64": "x86_64", "ppc64le": "ppc64le", "ppc64": "ppc64", "aarch64": "aarch64"}
+	versionPattern = regexp.MustCompile(`\d+(\.\d+)*`)
+)
+
+func _short_distro(product string, version string) string {
+	product = strings.ToLower(product)
+	for _, d := range DISTRO_NAMES {
+		if strings.HasPrefix(product, d.prefix) {
+			return d.short + version
+		}
+	}
+	return ""
+}
+
+// _parse_treeinfo reads the distro and the arch from the .treeinfo of the
+// Red Hat like distros, with either the [general] or the [release] and
+// [tree] sections.
+func _parse_treeinfo(content string) (string, string) {
+	values := make(map[string]string)
+	section := ""
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = line[1 : len(line)-1]
+			continue
+		}
+		if items := strings.SplitN(line, "=", 2); len(items) == 2 {
+			values[section+"."+strings.TrimSpace(items[0])] = strings.TrimSpace(items[1])
+		}
+	}
+	distro := _short_distro(values["release.name"], values["release.version"])
+	if distro == "" {
+		distro = _short_distro(values["general.family"], values["general.version"])
+	}
+	arch := values["tree.arch"]
+	if arch == "" {
+		arch = values["general.arch"]
+	}
+	return distro, ARCH_NAMES[arch]
+}
+
+// _parse_disk_info reads the distro and the arch from the .disk/info of the
+// debian like distros, like
+// Ubuntu-Server 16.04.3 LTS "Xenial Xerus" - Release amd64 (20170801)
+func _parse_disk_info(content string) (string, string) {
+	fields := strings.Fields(content)
+	if len(fields) < 2 {
+		return "", ""
+	}
+	distro := _short_distro(fields[0], versionPattern.FindString(fields[1]))
+	arch := ""
+	for _, field := range fields {
+		if value, ok := ARCH_NAMES[field]; ok {
+			arch = value
+		}
+	}
+	return distro, arch
+}
+
+// _detect_distro finds the distro and the arch of the ISO. The values not
+// found are returned empty.
+func _detect_distro(path string) (string, string, error) {
+	iso, err := utils.OpenISO(path)
+	if err != nil {
+		return "", "", err
+	}
+	defer iso.Close()
+	if content, err := iso.ReadFile(".treeinfo"); err == nil {
+		distro, arch := _parse_treeinfo(string(content))
+		return distro, arch, nil
+	}
+	if content, err := iso.ReadFile(".disk/info"); err == nil {
+		distro, arch := _parse_disk_info(string(content))
+		return distro, arch, nil
+	}
+	return "", "", nil
+}
+
+// progressBar draws a progress bar on a terminal, or prints a line every 10
+// percent otherwise.
+type progressBar struct {
+	label    string
+	total    int64
+	start    time.Time
+	terminal bool
+	printed  int64
+	drawn    time.Time
+	finished bool
+}
+
+func _progress_bar(label string, total int64) *progressBar {
+	return &progressBar{label: label, total: total, start: time.Now(), terminal: utils.IsTerminal(os.Stdout)}
+}
+
+func (p *progressBar) update(done int64) {
+	if p.finished {
+		return
+	}
+	percent := int64(100)
+	if p.total > 0 {
+		percent = done * 100 / p.total
+	}
+	p.finished = done >= p.total
+	if !p.terminal {
+		if percent >= p.printed+10 || p.finished {
+			fmt.Printf("%s: %d%%\n", p.label, percent)
+			p.printed = percent - percent%10
+		}
+		return
+	}
+	if time.Since(p.drawn) < 200*time.Millisecond && !p.finished {
+		return
+	}
+	p.drawn = time.Now()
+	rate := float64(done) / time.Since(p.start).Seconds() / (1 << 20)
+	bar := strings.Repeat("#", int(percent*30/100)) + strings.Repeat(".", 30-int(percent*30/100))
+	fmt.Printf("\r%s [%s] %3d%% %d/%d MiB %.1f MiB/s\033[K", p.label, bar, percent, done>>20, p.total>>20, rate)
+	if p.finished {
+		fmt.Println()
+	}
+}
+
+// progressReader reports the bytes read to a progress bar.
+type progressReader struct {
+	reader   io.Reader
+	progress *progressBar
+	done     int64
+}
+
+func (r *progressReader) Read(b []byte) (int, error) {
+	n, err := r.reader.Read(b)
+	r.done += int64(n)
+	r.progress.update(r.done)
+	return n, err
+}
+
+func _sha256_file(file *os.File, size int64) (string, error) {
+	hash := sha256.New()
+	reader := &progressReader{reader: io.NewSectionReader(file, 0, size), progress: _progress_bar("Checksum", size)}
+	if _, err := io.Copy(hash, reader); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// _upload_iso uploads the ISO in chunks and returns its sha256. The upload
+// is named after the sha256, so uploading the same ISO again resumes from
+// the bytes the server already holds.
+func _upload_iso(client *OsimageClient, path string, chunkSize int64) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return "", err
+	}
+	total := info.Size()
+	sum, err := _sha256_file(file, total)
+	if err != nil {
+		return "", err
+	}
+	offset, _, err := client.UploadStatus(sum)
+	if err != nil {
+		return "", err
+	}
+	if offset > 0 && offset < total {
+		fmt.Printf("Resuming the upload at %d MiB\n", offset>>20)
+	}
+	progress := _progress_bar("Upload", total)
+	progress.update(offset)
+	for retries := 0; offset < total; {
+		size := chunkSize
+		if offset+size > total {
+			size = total - offset
+		}
+		next, _, err := client.UploadChunk(sum, file, offset, size, total)
+		if err != nil {
+			if retries += 1; retries > 3 {
+				return "", err
+			}
+			time.Sleep(time.Duration(retries) * time.Second)
+			// ask the server where to resume as the chunk may be partly written
+			if next, _, err = client.UploadStatus(sum); err != nil {
+				return "", err
+			}
+		} else if next <= offset {
+			return "", fmt.Errorf("The server did not accept the chunk at %d.", offset)
+		} else {
+			retries = 0
+		}
+		offset = next
+		progress.update(offset)
+	}
+	// also when the upload was complete before, or resumed to the end
+	_, remote, err := client.UploadStatus(sum)
+	if err != nil {
+		return "", err
+	}
+	if remote == "" {
+		return "", fmt.Errorf("The server did not return the checksum of the upload %s.", sum)
+	}
+	if remote != sum {
+		return "", fmt.Errorf("Checksum mismatch, the server received %s but the ISO is %s.", remote, sum)
+	}
+	return sum, nil
+}
@@ -0,0 +1,115 @@
+package cmd
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/chenglch/golang-xcat3client/utils"
+	"github.com/spf13/cobra"
+)
+
+type UsageOsimageOptions struct {
+	attr string
+}
+
+var usageOsimageOpts *UsageOsimageOptions
+
+// OSIMAGE_NODE_ATTR is the node attribute holding the osimage the node is
+// deployed with. It is under extra, as the node schema has no osimage field.
+const OSIMAGE_NODE_ATTR = "extra.osimage"
+
+// _osimage_usage returns the names of the nodes referencing each osimage, or
+// only the given one. Only the attribute holding the osimage is requested, so
+// the scan stays fast on big clusters.
+func _osimage_usage(attr string, image string) (map[string][]string, error) {
+	client, err := NewNodeClient()
+	if err != nil {
+		return nil, err
+	}
+	path := strings.Split(attr, ".")
+	params := url.Values{}
+	if image != "" {
+		params.Set(attr, image)
+	}
+	nodes, err := _node_attrs(client, []string{path[0]}, params, nil)
+	if err != nil {
+		return nil, err
+	}
+	usage := make(map[string][]string)
+	for _, node := range nodes {
+		value, _ := utils.Lookup(node, path)
+		name, _ := node["name"].(string)
+		if osimage, ok := value.(string); ok && osimage != "" && (image == "" || osimage == image) {
+			usage[osimage] = append(usage[osimage], name)
+		}
+	}
+	for _, names := range usage {
+		utils.SortNatural(names)
+	}
+	return usage, nil
+}
+
+func UsageOsimage(cmd *cobra.Command, args []string) {
+	if len(args) > 1 {
+		fmt.Println("Please specify at most one osimage")
+		os.Exit(1)
+	}
+	images := args
+	if len(args) == 0 {
+		client, err := NewOsimageClient()
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		ret, err := client.Get("", nil)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		for _, value := range utils.InterfaceToSlice(ret.(map[string]interface{})["images"]) {
+			if name, ok := utils.InterfaceToMap(value)["name"].(string); ok {
+				images = append(images, name)
+			}
+		}
+	}
+	image := ""
+	if len(args) == 1 {
+		image = args[0]
+	}
+	usage, err := _osimage_usage(usageOsimageOpts.attr, image)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	// the nodes may reference osimages which are not registered
+	for name := range usage {
+		if exist, _ := utils.Contains(images, name); !exist {
+			images = append(images, name)
+		}
+	}
+	utils.SortNatural(images)
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "OSIMAGE\tCOUNT\tNODES")
+	for _, name := range images {
+		fmt.Fprintf(w, "%s\t%d\t%s\n", name, len(usage[name]), strings.Join(usage[name], ","))
+	}
+	w.Flush()
+}
+
+func UsageOsimageCommand() *cobra.Command {
+	usageOsimageOpts = new(UsageOsimageOptions)
+	cmd := &cobra.Command{
+		Use:   "usage [<osimage name>] [--attr <attribute>]",
+		Short: "List the nodes referencing osimage(s).",
+		Long: `List the nodes referencing each osimage, or only the given one. Format: usage [<osimage name>]
+		The osimage of a node is read from its extra.osimage attribute, or from the one given by
+		--attr like --attr extra.provmethod.`,
+		Run: UsageOsimage,
+	}
+	cmd.Flags().StringVarP(&usageOsimageOpts.attr, "attr", "", OSIMAGE_NODE_ATTR,
+		`Node attribute holding the osimage of the node.`)
+	return cmd
+}
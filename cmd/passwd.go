@@ -2,6 +2,7 @@ package cmd
 
 import (
 	"fmt"
+	"io/ioutil"
 	"os"
 	"strings"
 
@@ -11,12 +12,72 @@ import (
 
 type ShowPasswdOptions struct {
 	fields string
+	reveal bool
+}
+
+type PasswdInputOptions struct {
+	prompt bool
+	stdin  bool
 }
 
 var (
-	showPasswdOpts *ShowPasswdOptions
+	showPasswdOpts   *ShowPasswdOptions
+	createPasswdOpts *PasswdInputOptions
+	updatePasswdOpts *PasswdInputOptions
 )
 
+func _add_password_flags(cmd *cobra.Command, opts *PasswdInputOptions) {
+	cmd.Flags().BoolVarP(&opts.prompt, "prompt", "p", false,
+		`Prompt for the password without echo, instead of password=<password>.`)
+	cmd.Flags().BoolVarP(&opts.stdin, "password-stdin", "", false,
+		`Read the password from stdin, instead of password=<password>.`)
+}
+
+// _read_password returns the password from the prompt or from stdin, and
+// false when neither is requested.
+func _read_password(opts *PasswdInputOptions) (string, bool, error) {
+	if opts.prompt && opts.stdin {
+		return "", false, fmt.Errorf("--prompt and --password-stdin are mutually exclusive.")
+	}
+	password := ""
+	if opts.prompt {
+		first, err := utils.ReadPassword("Password: ")
+		if err != nil {
+			return "", false, err
+		}
+		second, err := utils.ReadPassword("Retype password: ")
+		if err != nil {
+			return "", false, err
+		}
+		if first != second {
+			return "", false, fmt.Errorf("The passwords do not match.")
+		}
+		password = first
+	} else if opts.stdin {
+		content, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			return "", false, err
+		}
+		password = strings.TrimRight(string(content), "\r\n")
+	} else {
+		return "", false, nil
+	}
+	if password == "" {
+		return "", false, fmt.Errorf("The password is empty.")
+	}
+	return password, true, nil
+}
+
+// _hash_password hashes the password on the client for the crypt methods it
+// knows, so that only the hash is sent. Passwords already in crypt format and
+// the other crypt methods are left to the server.
+func _hash_password(method string, password string) (string, error) {
+	if _, ok := utils.CRYPT_METHODS[method]; !ok || utils.IsCrypted(password) {
+		return password, nil
+	}
+	return utils.Crypt(method, password)
+}
+
 func ListPasswd(cmd *cobra.Command, args []string) {
 	client, err := NewPasswdClient()
 	if err != nil {
@@ -71,30 +132,58 @@ func ShowPasswd(cmd *cobra.Command, args []string) {
 			os.Exit(1)
 		}
 	}
+	if result != nil && !showPasswdOpts.reveal {
+		if result, err = utils.MaskSecretsJson(result.([]byte)); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
 	utils.PrintJson(result)
 }
 
 func ShowPasswdCommand() *cobra.Command {
 	showPasswdOpts = new(ShowPasswdOptions)
 	cmd := &cobra.Command{
-		Use:   "show <passwd key>",
+		Use:   "show <passwd key> [--reveal]",
 		Short: "Show detailed infomation about passwds.",
-		Long:  `Show detailed infomation about passwds. Format: show <passwd name>`,
-		Run:   ShowPasswd,
+		Long: `Show detailed infomation about passwds. Format: show <passwd name> [--reveal]
+		The password is masked unless --reveal is given.`,
+		Run: ShowPasswd,
 	}
 	cmd.Flags().StringVarP(&showPasswdOpts.fields, "fields", "i", "",
 		`Fields seperated by comma. Only these fields will be fetched from the server.`)
+	cmd.Flags().BoolVarP(&showPasswdOpts.reveal, "reveal", "", false,
+		`Show the password instead of masking it.`)
 	return cmd
 }
 
 func CreatePasswd(cmd *cobra.Command, args []string) {
 	var result interface{}
-	attr_map, err := utils.KeyValueArrayToMap(args[1:], "=")
+	attr_map, err := utils.KeyValueArrayToMap(args[1:])
 	if err != nil {
 		fmt.Println(err)
 		os.Exit(1)
 	}
 	attr_map["key"] = args[0]
+	password, given, err := _read_password(createPasswdOpts)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if given {
+		if _, ok := attr_map["password"]; ok {
+			fmt.Println("Please specify the password either with password=<password> or with --prompt/--password-stdin")
+			os.Exit(1)
+		}
+		attr_map["password"] = password
+	}
+	if password, ok := attr_map["password"].(string); ok {
+		method, _ := attr_map["crypt_method"].(string)
+		if attr_map["password"], err = _hash_password(method, password); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
 	client, err := NewPasswdClient()
 	if err != nil {
 		fmt.Println(err)
@@ -106,6 +195,10 @@ func CreatePasswd(cmd *cobra.Command, args []string) {
 			fmt.Println(err)
 			os.Exit(1)
 		}
+		if result, err = utils.MaskSecretsJson(result.([]byte)); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
 	} else {
 		fmt.Println("Pleace specify the attribute key values in key1=val1 key2=val2 format")
 		os.Exit(1)
@@ -114,13 +207,18 @@ func CreatePasswd(cmd *cobra.Command, args []string) {
 }
 
 func CreatePasswdCommand() *cobra.Command {
+	createPasswdOpts = new(PasswdInputOptions)
 	cmd := &cobra.Command{
-		Use:   "create <passwd key> <key=val> [key=val]",
+		Use:   "create <passwd key> <key=val> [key=val] [--prompt] [--password-stdin]",
 		Short: "Register passwds into xCAT3 service.",
 		Long: `Register passwds into xCAT3 service. Format: create <passwd name> <key=val> [key=val]
-		Current valied fields 'username', 'password', 'crypt_method'`,
+		Current valied fields 'username', 'password', 'crypt_method'
+		With --prompt or --password-stdin the password is not given on the command line, like
+		create system username=root crypt_method=sha512crypt --prompt
+		The password is hashed before it is sent when crypt_method is sha512crypt, sha256crypt or md5crypt.`,
 		Run: CreatePasswd,
 	}
+	_add_password_flags(cmd, createPasswdOpts)
 	return cmd
 }
 
@@ -153,17 +251,71 @@ func DeletePasswdCommand() *cobra.Command {
 	return cmd
 }
 
+// _hash_password_patch hashes the password set by the patches, with the crypt
+// method set by the patches or else with the one of the passwd entry.
+func _hash_password_patch(client *PasswdClient, key string, patches []map[string]interface{}) error {
+	var passwordPatch map[string]interface{}
+	method := ""
+	for _, patch := range patches {
+		switch patch["path"] {
+		case "/password":
+			passwordPatch = patch
+		case "/crypt_method":
+			method, _ = patch["value"].(string)
+		}
+	}
+	password, ok := passwordPatch["value"].(string)
+	if !ok {
+		return nil
+	}
+	if method == "" {
+		result, err := client.Show(key, []string{"crypt_method"}, nil, false)
+		if err != nil {
+			return err
+		}
+		method, _ = utils.InterfaceToMap(result)["crypt_method"].(string)
+	}
+	hashed, err := _hash_password(method, password)
+	if err != nil {
+		return err
+	}
+	passwordPatch["value"] = hashed
+	return nil
+}
+
 func UpdatePasswd(cmd *cobra.Command, args []string) {
-	if len(args) < 2 {
+	password, given, err := _read_password(updatePasswdOpts)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if len(args) < 1 || len(args) < 2 && !given {
 		fmt.Println("Please specify the name of passwds and attribute in key=val format to update")
 		os.Exit(1)
 	}
-	patches := arg_array_to_patch(args[1:])
+	patches, err := arg_array_to_patch(args[1:])
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if given {
+		for _, patch := range patches {
+			if patch["path"] == "/password" {
+				fmt.Println("Please specify the password either with password=<password> or with --prompt/--password-stdin")
+				os.Exit(1)
+			}
+		}
+		patches = append(patches, map[string]interface{}{"op": "add", "path": "/password", "value": password})
+	}
 	client, err := NewPasswdClient()
 	if err != nil {
 		fmt.Println(err)
 		os.Exit(1)
 	}
+	if err = _hash_password_patch(client, args[0], patches); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
 	_, err = client.Patch(args[0], patches, true)
 	if err != nil {
 		fmt.Println(err)
@@ -173,13 +325,18 @@ func UpdatePasswd(cmd *cobra.Command, args []string) {
 }
 
 func UpdatePasswdCommand() *cobra.Command {
+	updatePasswdOpts = new(PasswdInputOptions)
 	cmd := &cobra.Command{
-		Use:   "update <passwd name> <key=val> [<key=val>]",
+		Use:   "update <passwd name> <key=val> [<key=val>] [--prompt] [--password-stdin]",
 		Short: "Update information about registered passwds.",
 		Long: `Update information about registered passwds. Format: update <passwd name> <key=val> [<key=val>]
-		Current valied fields 'username', 'password', 'crypt_method'`,
+		Current valied fields 'username', 'password', 'crypt_method'
+		With --prompt or --password-stdin the password is not given on the command line, like
+		update system --prompt
+		The password is hashed before it is sent when crypt_method is sha512crypt, sha256crypt or md5crypt.`,
 		Run: UpdatePasswd,
 	}
+	_add_password_flags(cmd, updatePasswdOpts)
 	return cmd
 }
 
@@ -0,0 +1,302 @@
+package cmd
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chenglch/golang-xcat3client/utils"
+	"github.com/spf13/cobra"
+)
+
+type RotateBmcOptions struct {
+	shared     bool
+	length     int
+	record     string
+	recipients []string
+}
+
+// RotateRecord is a node in the local record of the rotated passwords. The
+// record is written with the bmc status pending before any BMC is changed,
+// as it may then be the only copy of a password, and written again with the
+// final status.
+type RotateRecord struct {
+	Password string `json:"password"`
+	Bmc      string `json:"bmc"`
+	Node     string `json:"node"`
+	Verify   string `json:"verify"`
+}
+
+var (
+	rotateBmcOpts *RotateBmcOptions
+	// PASSWORD_CLASSES are the characters of the generated passwords, which
+	// hold at least one of each class. The symbols are safe for the BMCs and
+	// the shells.
+	PASSWORD_CLASSES = []string{"ABCDEFGHJKLMNPQRSTUVWXYZ", "abcdefghijkmnopqrstuvwxyz", "23456789", "-_.,:%@+="}
+	// ROTATE_PARALLEL is the number of concurrent node updates when each
+	// node has its own password.
+	ROTATE_PARALLEL = 16
+)
+
+func _random_index(n int) (int, error) {
+	i, err := rand.Int(rand.Reader, big.NewInt(int64(n)))
+	if err != nil {
+		return 0, err
+	}
+	return int(i.Int64()), nil
+}
+
+// _generate_password returns a random password with a character of each of
+// the PASSWORD_CLASSES at least.
+func _generate_password(length int) (string, error) {
+	chars := strings.Join(PASSWORD_CLASSES, "")
+	for {
+		password := make([]byte, length)
+		for i := range password {
+			index, err := _random_index(len(chars))
+			if err != nil {
+				return "", err
+			}
+			password[i] = chars[index]
+		}
+		complete := true
+		for _, class := range PASSWORD_CLASSES {
+			if !strings.ContainsAny(string(password), class) {
+				complete = false
+			}
+		}
+		if complete {
+			return string(password), nil
+		}
+	}
+}
+
+// _patch_bmc_password sets control_info.bmc_password of the nodes and returns
+// the result of each node.
+func _patch_bmc_password(names []string, password string) map[string]string {
+	data := map[string]interface{}{"nodes": make([]interface{}, 0, len(names)),
+		"patches": []map[string]interface{}{{"op": "add", "path": "/control_info/bmc_password", "value": password}}}
+	for _, name := range names {
+		data["nodes"] = append(data["nodes"].([]interface{}), map[string]string{"name": name})
+	}
+	status := make(map[string]string, len(names))
+	client, err := NewNodeClient()
+	var result map[string]interface{}
+	if err == nil {
+		result, err = client.Patch("", data)
+	}
+	for _, name := range names {
+		if err != nil {
+			status[name] = err.Error()
+		} else {
+			status[name] = fmt.Sprint(utils.InterfaceToMap(result["nodes"])[name])
+		}
+	}
+	return status
+}
+
+// _write_rotate_record encrypts the records to the path. The file is replaced
+// only once gpg succeeded, so that a failed write keeps the previous record.
+func _write_rotate_record(path string, records map[string]*RotateRecord, recipients []string) error {
+	content, err := json.MarshalIndent(map[string]interface{}{"created": time.Now().Format(time.RFC3339), "nodes": records}, "", "\t")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err = utils.GpgEncrypt(content, tmp, recipients); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// _update_bmc_passwords sets control_info.bmc_password of the nodes, with one
+// request for a shared password and one request per node otherwise.
+func _update_bmc_passwords(names []string, passwords map[string]string, shared bool) map[string]string {
+	if shared {
+		return _patch_bmc_password(names, passwords[names[0]])
+	}
+	status := make(map[string]string, len(names))
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	queue := make(chan string)
+	for i := 0; i < ROTATE_PARALLEL; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for name := range queue {
+				ret := _patch_bmc_password([]string{name}, passwords[name])
+				mutex.Lock()
+				status[name] = ret[name]
+				mutex.Unlock()
+			}
+		}()
+	}
+	for _, name := range names {
+		queue <- name
+	}
+	close(queue)
+	wg.Wait()
+	return status
+}
+
+func RotateBmc(cmd *cobra.Command, args []string) {
+	if len(args) != 1 {
+		fmt.Println("Please specify the node range")
+		os.Exit(1)
+	}
+	if rotateBmcOpts.length < 8 || rotateBmcOpts.length > 20 {
+		fmt.Println("The length of the password must be between 8 and 20, the limit of IPMI 2.0.")
+		os.Exit(1)
+	}
+	// fail before changing any BMC if the passwords could not be recorded
+	if err := utils.GpgAvailable(rotateBmcOpts.recipients); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	record := rotateBmcOpts.record
+	if record == "" {
+		record = "bmc-passwords-" + time.Now().Format("20060102-150405") + ".json.gpg"
+	}
+	names, err := _expand_noderange(args[0])
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	passwords := make(map[string]string, len(names))
+	records := make(map[string]*RotateRecord, len(names))
+	shared := ""
+	for _, name := range names {
+		if shared == "" || !rotateBmcOpts.shared {
+			if shared, err = _generate_password(rotateBmcOpts.length); err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+		}
+		passwords[name] = shared
+		records[name] = &RotateRecord{Password: shared, Bmc: "pending"}
+	}
+	client, err := NewNodeClient()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if err = _write_rotate_record(record, records, rotateBmcOpts.recipients); err != nil {
+		fmt.Printf("Could not write the record %s, no BMC was changed: %s\n", record, err)
+		os.Exit(1)
+	}
+
+	data := map[string]interface{}{"nodes": make([]interface{}, 0, len(names))}
+	for _, name := range names {
+		data["nodes"] = append(data["nodes"].([]interface{}), map[string]string{"name": name, "password": passwords[name]})
+	}
+	ret, err := client.SetBmcPassword(data)
+	if err != nil {
+		fmt.Println(err)
+		fmt.Printf("The BMC password of the node(s) may have changed, the new password(s) are recorded in %s\n", record)
+		os.Exit(1)
+	}
+	result := map[string]interface{}{"nodes": make(map[string]interface{})}
+	nodes := result["nodes"].(map[string]interface{})
+	changed := make([]string, 0, len(names))
+	status := utils.InterfaceToMap(ret["nodes"])
+	for _, name := range names {
+		v, ok := status[name]
+		if !ok {
+			// the BMC may or may not have the new password
+			records[name].Bmc = "no result"
+			nodes[name] = "BMC password unknown: no result from the xCAT3 service"
+			continue
+		}
+		records[name].Bmc = fmt.Sprint(v)
+		if _, ok := SUCCESS_RESULTS[records[name].Bmc]; ok {
+			changed = append(changed, name)
+		} else {
+			nodes[name] = fmt.Sprintf("BMC password not changed: %v", v)
+		}
+	}
+
+	// the node attribute follows the BMC only where the BMC changed
+	updated := make([]string, 0, len(changed))
+	if len(changed) > 0 {
+		utils.SortNatural(changed)
+		for name, status := range _update_bmc_passwords(changed, passwords, rotateBmcOpts.shared) {
+			records[name].Node = status
+			if _, ok := SUCCESS_RESULTS[status]; ok {
+				updated = append(updated, name)
+			}
+		}
+	}
+	if len(updated) > 0 {
+		states, err := _node_states(client, "power", updated)
+		if err != nil {
+			states = make(map[string]string)
+			for _, name := range updated {
+				states[name] = err.Error()
+			}
+		}
+		for _, name := range updated {
+			records[name].Verify = states[name]
+		}
+	}
+	for _, name := range changed {
+		r := records[name]
+		switch {
+		case r.Verify == "":
+			nodes[name] = fmt.Sprintf("BMC password changed but node not updated: %s", r.Node)
+		case r.Verify != "on" && r.Verify != "off":
+			nodes[name] = fmt.Sprintf("BMC password changed but power status failed: %s", r.Verify)
+		default:
+			nodes[name] = "updated"
+		}
+	}
+
+	err = _write_rotate_record(record, records, rotateBmcOpts.recipients)
+	_print_node_result(result)
+	if err != nil {
+		fmt.Printf("Could not write the final status to the record %s: %s\n", record, err)
+		fmt.Printf("The new password(s) are recorded in %s with the BMC status pending.\n", record)
+		os.Exit(1)
+	}
+	fmt.Printf("The new password(s) are recorded in %s\n", record)
+	if len(changed) == 0 {
+		os.Exit(1)
+	}
+}
+
+func RotateBmcCommand() *cobra.Command {
+	rotateBmcOpts = new(RotateBmcOptions)
+	cmd := &cobra.Command{
+		Use:   "rotate-bmc <node range> [--shared] [--length <n>] [--record <file>] [--recipient <gpg key>]",
+		Short: "Rotate the BMC password of node(s).",
+		Long: `Rotate the BMC password of node(s). Format: rotate-bmc <node range> [--shared] [--length <n>]
+		A strong password is generated for each node, or one for all the nodes with --shared, and
+		set on the BMCs through the xCAT3 service. control_info.bmc_password of a node is updated
+		only when its BMC accepted the password, then checked with power status.
+		The new passwords are written to a local file encrypted with gpg, for the --recipient keys or
+		with a passphrase asked by gpg, before any BMC is changed, then written again with the status
+		of each node, like
+		rotate-bmc rack[1-4] --recipient admin@example.com --record bmc.json.gpg
+		gpg --decrypt bmc.json.gpg`,
+		Run: RotateBmc,
+	}
+	cmd.Flags().BoolVarP(&rotateBmcOpts.shared, "shared", "", false,
+		`Use one password for all the nodes instead of one per node.`)
+	cmd.Flags().IntVarP(&rotateBmcOpts.length, "length", "", 16,
+		`Length of the generated passwords, from 8 to 20.`)
+	cmd.Flags().StringVarP(&rotateBmcOpts.record, "record", "r", "",
+		`Encrypted file to record the passwords in, bmc-passwords-<time>.json.gpg by default.`)
+	cmd.Flags().StringSliceVarP(&rotateBmcOpts.recipients, "recipient", "", nil,
+		`gpg key to encrypt the record for, may be repeated. A passphrase is asked without it.`)
+	_add_selector_flag(cmd)
+	return cmd
+}
+
+func init() {
+	RootCmd.AddCommand(RotateBmcCommand())
+}
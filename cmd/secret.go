@@ -0,0 +1,138 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/chenglch/golang-xcat3client/utils"
+	"github.com/spf13/cobra"
+)
+
+type SetSecretOptions struct {
+	recipients []string
+}
+
+var setSecretOpts *SetSecretOptions
+
+func SetSecret(cmd *cobra.Command, args []string) {
+	if len(args) != 1 || args[0] == "" || strings.ContainsAny(args[0], " \t\n") {
+		fmt.Println("Please specify the name of the secret, like bmc/rack12")
+		os.Exit(1)
+	}
+	name := strings.TrimPrefix(args[0], utils.SECRET_SCHEME)
+	// prompt on a terminal, read a pipe otherwise
+	terminal := utils.IsTerminal(os.Stdin)
+	value, _, err := _read_password(&PasswdInputOptions{prompt: terminal, stdin: !terminal})
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	store, err := utils.OpenSecretStore()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if len(setSecretOpts.recipients) > 0 {
+		store.Recipients = setSecretOpts.recipients
+	}
+	store.Secrets[name] = value
+	if err = store.Save(); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	fmt.Printf("%s%s: saved\n", utils.SECRET_SCHEME, name)
+}
+
+func SetSecretCommand() *cobra.Command {
+	setSecretOpts = new(SetSecretOptions)
+	cmd := &cobra.Command{
+		Use:   "set <name> [--recipient <gpg key>]",
+		Short: "Save a secret into the local secret store.",
+		Long: `Save a secret into the local secret store. Format: set <name> [--recipient <gpg key>]
+		The secret is asked without echo, or read from stdin when it is not a terminal, like
+		pass show bmc | xcat3 secret set bmc/rack12
+		The node attributes then reference it as secret://bmc/rack12, which is replaced with the
+		secret when the node(s) are created, imported or updated, like
+		xcat3 create r12n[01-40] --control bmc_address=11.0.12.{index+1},bmc_password=secret://bmc/rack12
+		The store is ~/.xcat3/secrets.json.gpg, or the file given by XCAT3_SECRETS, encrypted with
+		gpg for the --recipient keys, which are kept for the next changes, or with a passphrase.`,
+		Run: SetSecret,
+	}
+	cmd.Flags().StringSliceVarP(&setSecretOpts.recipients, "recipient", "", nil,
+		`gpg key to encrypt the store for, may be repeated. Replaces the keys of the store.`)
+	return cmd
+}
+
+func GetSecret(cmd *cobra.Command, args []string) {
+	if len(args) != 1 {
+		fmt.Println("Please specify the name of the secret")
+		os.Exit(1)
+	}
+	name := strings.TrimPrefix(args[0], utils.SECRET_SCHEME)
+	store, err := utils.OpenSecretStore()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	value, ok := store.Secrets[name]
+	if !ok {
+		fmt.Printf("Could not find %s%s in the secret store %s\n", utils.SECRET_SCHEME, name, store.Path())
+		os.Exit(1)
+	}
+	fmt.Println(value)
+}
+
+func GetSecretCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "get <name>",
+		Short: "Print a secret of the local secret store.",
+		Long:  `Print a secret of the local secret store. Format: get <name>`,
+		Run:   GetSecret,
+	}
+	return cmd
+}
+
+func ListSecret(cmd *cobra.Command, args []string) {
+	store, err := utils.OpenSecretStore()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	names := store.Names()
+	if len(names) == 0 {
+		fmt.Println("Could not find any record")
+		os.Exit(1)
+	}
+	for _, name := range names {
+		fmt.Printf("%s%s\n", utils.SECRET_SCHEME, name)
+	}
+}
+
+func ListSecretCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "List the secrets of the local secret store.",
+		Long:  `List the names of the secrets of the local secret store. Format: list`,
+		Run:   ListSecret,
+	}
+	return cmd
+}
+
+func SecretCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "secret",
+		Short: "This is secret child command for xcat3",
+		Long: `xcat3 secret --help and xcat3 secret help COMMAND to see the usage for specfied
+	command.`,
+	}
+	return cmd
+}
+
+func init() {
+	SecretCmd := SecretCommand()
+	SecretCmd.AddCommand(SetSecretCommand())
+	SecretCmd.AddCommand(GetSecretCommand())
+	SecretCmd.AddCommand(ListSecretCommand())
+	RootCmd.AddCommand(SecretCmd)
+}
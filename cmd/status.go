@@ -0,0 +1,218 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/chenglch/golang-xcat3client/utils"
+	"github.com/spf13/cobra"
+)
+
+type StatusOptions struct {
+	only []string
+}
+
+var (
+	statusOpts *StatusOptions
+	// STATUS_COLUMNS are the columns of the status table after the node name.
+	STATUS_COLUMNS = []string{"power", "bootdev", "state", "conductor"}
+)
+
+// _fetch_status gets the power state, the boot device, the provision state
+// and the conductor of the nodes at the same time. The column of a source
+// which fails is left as unknown and the errors are returned.
+func _fetch_status(client *NodeClient, names []string) (map[string]map[string]string, []error) {
+	status := make(map[string]map[string]string, len(names))
+	wanted := make(map[string]bool, len(names))
+	for _, name := range names {
+		status[name] = map[string]string{"power": "unknown", "bootdev": "unknown", "state": "unknown", "conductor": "unknown"}
+		wanted[name] = true
+	}
+	var lock sync.Mutex
+	errs := make([]error, 0)
+	fail := func(format string, err error) {
+		lock.Lock()
+		defer lock.Unlock()
+		errs = append(errs, fmt.Errorf(format, err))
+	}
+	set := func(column string, values map[string]string) {
+		lock.Lock()
+		defer lock.Unlock()
+		for name, value := range values {
+			if row, ok := status[name]; ok {
+				row[column] = value
+			}
+		}
+	}
+	var wg sync.WaitGroup
+	wg.Add(3)
+	go func() {
+		defer wg.Done()
+		states, err := _node_states(client, "power", names)
+		if err != nil {
+			fail("Could not get the power state: %s", err)
+			return
+		}
+		set("power", states)
+	}()
+	go func() {
+		defer wg.Done()
+		result, err := client.Get("boot_device", client.ToNodesMap(names))
+		if err != nil {
+			fail("Could not get the boot device: %s", err)
+			return
+		}
+		devices := make(map[string]string)
+		for name, v := range utils.InterfaceToMap(utils.InterfaceToMap(result)["nodes"]) {
+			if info, ok := v.(map[string]interface{}); ok {
+				v = info["boot_device"]
+			}
+			devices[name] = fmt.Sprint(v)
+		}
+		set("bootdev", devices)
+	}()
+	go func() {
+		defer wg.Done()
+		nodes, err := _node_attrs(client, []string{"state", "conductor_affinity"}, nil, wanted)
+		if err != nil {
+			fail("Could not get the provision state: %s", err)
+			return
+		}
+		states := make(map[string]string, len(nodes))
+		conductors := make(map[string]string, len(nodes))
+		for _, node := range nodes {
+			name := node["name"].(string)
+			if v, ok := node["state"]; ok && v != nil {
+				states[name] = fmt.Sprint(v)
+			}
+			if v, ok := node["conductor_affinity"]; ok && v != nil {
+				conductors[name] = fmt.Sprint(v)
+			}
+		}
+		set("state", states)
+		set("conductor", conductors)
+	}()
+	wg.Wait()
+	return status, errs
+}
+
+// _match_status reports whether the row matches all the --only items. An
+// item is column=value, or a value matching any column.
+func _match_status(row map[string]string, only []string) bool {
+	for _, item := range only {
+		matched := false
+		if kv := strings.SplitN(item, "=", 2); len(kv) == 2 {
+			matched = row[kv[0]] == kv[1]
+		} else {
+			for _, column := range STATUS_COLUMNS {
+				if row[column] == item {
+					matched = true
+					break
+				}
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// _print_status_counts prints the number of nodes in each state of each
+// column, like power: on=10 off=2.
+func _print_status_counts(names []string, status map[string]map[string]string) {
+	for _, column := range STATUS_COLUMNS {
+		counts := make(map[string]int)
+		for _, name := range names {
+			counts[status[name][column]] += 1
+		}
+		values := make([]string, 0, len(counts))
+		for value := range counts {
+			values = append(values, value)
+		}
+		sort.Slice(values, func(i, j int) bool {
+			if counts[values[i]] != counts[values[j]] {
+				return counts[values[i]] > counts[values[j]]
+			}
+			return values[i] < values[j]
+		})
+		items := make([]string, 0, len(values))
+		for _, value := range values {
+			items = append(items, fmt.Sprintf("%s=%d", value, counts[value]))
+		}
+		fmt.Printf("%s: %s\n", column, strings.Join(items, " "))
+	}
+}
+
+func Status(cmd *cobra.Command, args []string) {
+	if len(args) < 1 {
+		fmt.Println("Please specify the node range.")
+		os.Exit(1)
+	}
+	for _, item := range statusOpts.only {
+		if kv := strings.SplitN(item, "=", 2); len(kv) == 2 {
+			if exist, _ := utils.Contains(STATUS_COLUMNS, kv[0]); !exist {
+				fmt.Printf("Unknown column %s, expected one of %s.\n", kv[0], strings.Join(STATUS_COLUMNS, " "))
+				os.Exit(1)
+			}
+		}
+	}
+	names, err := _expand_noderange(args[0])
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	client, err := NewNodeClient()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	status, errs := _fetch_status(client, names)
+	for _, err := range errs {
+		fmt.Println(err)
+	}
+	shown := make([]string, 0, len(names))
+	for _, name := range names {
+		if _match_status(status[name], statusOpts.only) {
+			shown = append(shown, name)
+		}
+	}
+	if len(shown) == 0 {
+		fmt.Println("Could not find any record")
+		os.Exit(1)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "NODE\tPOWER\tBOOTDEV\tSTATE\tCONDUCTOR")
+	for _, name := range shown {
+		row := status[name]
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", name, row["power"], row["bootdev"], row["state"], row["conductor"])
+	}
+	w.Flush()
+	fmt.Println()
+	_print_status_counts(shown, status)
+}
+
+func StatusCommand() *cobra.Command {
+	statusOpts = new(StatusOptions)
+	cmd := &cobra.Command{
+		Use:   "status <node range> [--only <value>]",
+		Short: "Show the power state, boot device, provision state and conductor of node(s).",
+		Long: `Show the power state, boot device, provision state and conductor of node(s), one row
+		per node followed by the number of node(s) in each state. Format: status <node range> [--only <value>]
+		--only keeps the node(s) with a column equal to the value, or with the given column=value,
+		like status compute --only off or status compute --only state=deployed`,
+		Run: Status,
+	}
+	cmd.Flags().StringSliceVarP(&statusOpts.only, "only", "", nil,
+		`Only show the node(s) matching the value or column=value. May be repeated.`)
+	_add_selector_flag(cmd)
+	return cmd
+}
+
+func init() {
+	RootCmd.AddCommand(StatusCommand())
+}
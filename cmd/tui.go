@@ -0,0 +1,586 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+	"unicode/utf8"
+
+	"github.com/chenglch/golang-xcat3client/utils"
+	"github.com/spf13/cobra"
+)
+
+type TuiOptions struct {
+	refresh time.Duration
+	where   string
+}
+
+var (
+	tuiOpts *TuiOptions
+	// TUI_KEYS maps the escape sequences of the terminal to key names.
+	TUI_KEYS = map[string]string{"A": "up", "B": "down", "C": "right", "D": "left",
+		"H": "home", "F": "end", "5~": "pgup", "6~": "pgdown"}
+	TUI_HELP = "up/down move  space select  a all  enter show  / range  f filter  " +
+		"p power  b bootdev  d deploy  e edit  r refresh  q quit"
+)
+
+// tuiView is the state of the terminal UI. The list shows the nodes of the
+// node range matching the filter, and the detail view the JSON of a node.
+type tuiView struct {
+	client    *NodeClient
+	keys      chan string
+	noderange string
+	where     string
+	names     []string
+	status    map[string]map[string]string
+	selected  map[string]bool
+	cursor    int
+	offset    int
+	detail    []string
+	title     string
+	message   string
+	prompt    string
+	input     string
+	// rows and cols are the size of the terminal, refreshed on SIGWINCH.
+	rows int
+	cols int
+}
+
+// _read_keys reads the keys pressed in the terminal and sends their names,
+// or the typed character, to the channel.
+func _read_keys(keys chan<- string) {
+	buf := make([]byte, 256)
+	for {
+		n, err := os.Stdin.Read(buf)
+		if err != nil {
+			close(keys)
+			return
+		}
+		for i := 0; i < n; {
+			switch b := buf[i]; {
+			case b == 27 && i+2 < n && (buf[i+1] == '[' || buf[i+1] == 'O'):
+				j := i + 2
+				for j < n-1 && (buf[j] < 0x40 || buf[j] > 0x7e) {
+					j++
+				}
+				if key, ok := TUI_KEYS[string(buf[i+2:j+1])]; ok {
+					keys <- key
+				}
+				i = j + 1
+			case b == 27:
+				keys <- "esc"
+				i++
+			case b == '\r' || b == '\n':
+				keys <- "enter"
+				i++
+			case b == 127 || b == 8:
+				keys <- "backspace"
+				i++
+			case b == 3:
+				keys <- "ctrl-c"
+				i++
+			case b == ' ':
+				keys <- "space"
+				i++
+			default:
+				r, size := utf8.DecodeRune(buf[i:n])
+				if r != utf8.RuneError && r >= ' ' {
+					keys <- string(r)
+				}
+				i += size
+			}
+		}
+	}
+}
+
+func _fit(s string, width int) string {
+	if width <= 0 {
+		return ""
+	}
+	if utf8.RuneCountInString(s) <= width {
+		return s
+	}
+	return string([]rune(s)[:width])
+}
+
+func (v *tuiView) draw() {
+	rows, cols := v.rows, v.cols
+	height := rows - 4
+	if height < 1 {
+		height = 1
+	}
+	lines := make([]string, 0, rows)
+	lines = append(lines, "\033[7m"+_fit(v.header(), cols)+"\033[K\033[0m")
+	if v.detail != nil {
+		if v.offset > len(v.detail)-1 {
+			v.offset = len(v.detail) - 1
+		}
+		if v.offset < 0 {
+			v.offset = 0
+		}
+		lines = append(lines, _fit(v.title, cols))
+		for i := v.offset; i < len(v.detail) && i < v.offset+height; i++ {
+			lines = append(lines, _fit(v.detail[i], cols))
+		}
+	} else {
+		if v.cursor < v.offset {
+			v.offset = v.cursor
+		} else if v.cursor >= v.offset+height {
+			v.offset = v.cursor - height + 1
+		}
+		widths := []int{4, 5, 7, 5, 9}
+		for _, name := range v.names {
+			row := v.status[name]
+			for i, value := range []string{name, row["power"], row["bootdev"], row["state"], row["conductor"]} {
+				if len(value) > widths[i] {
+					widths[i] = len(value)
+				}
+			}
+		}
+		format := fmt.Sprintf("%%s %%-%ds  %%-%ds  %%-%ds  %%-%ds  %%s", widths[0], widths[1], widths[2], widths[3])
+		lines = append(lines, _fit(fmt.Sprintf(format, "    ", "NODE", "POWER", "BOOTDEV", "STATE", "CONDUCTOR"), cols))
+		for i := v.offset; i < len(v.names) && i < v.offset+height; i++ {
+			name := v.names[i]
+			row := v.status[name]
+			mark := "[ ]"
+			if v.selected[name] {
+				mark = "[x]"
+			}
+			line := _fit(fmt.Sprintf(format, " "+mark, name, row["power"], row["bootdev"], row["state"], row["conductor"]), cols)
+			if i == v.cursor {
+				line = "\033[7m" + line + "\033[K\033[0m"
+			}
+			lines = append(lines, line)
+		}
+	}
+	for len(lines) < rows-2 {
+		lines = append(lines, "")
+	}
+	lines = append(lines, _fit(v.message, cols))
+	if v.prompt != "" {
+		lines = append(lines, _fit(v.prompt+v.input, cols)+"\033[?25h")
+	} else {
+		lines = append(lines, "\033[?25l"+_fit(TUI_HELP, cols))
+	}
+	var buf bytes.Buffer
+	buf.WriteString("\033[H")
+	for i, line := range lines {
+		buf.WriteString(line + "\033[K")
+		if i < len(lines)-1 {
+			buf.WriteString("\r\n")
+		}
+	}
+	buf.WriteString("\033[J")
+	os.Stdout.Write(buf.Bytes())
+}
+
+func (v *tuiView) header() string {
+	noderange := v.noderange
+	if noderange == "" {
+		noderange = "all"
+	}
+	where := v.where
+	if where == "" {
+		where = "-"
+	}
+	return fmt.Sprintf(" xcat3 tui  nodes: %d  selected: %d  range: %s  filter: %s",
+		len(v.names), len(v.selected), noderange, where)
+}
+
+// ask shows the question on the last line and returns the typed answer, or
+// false when the question is cancelled with esc.
+func (v *tuiView) ask(question string, value string) (string, bool) {
+	v.prompt, v.input = question, value
+	defer func() {
+		v.prompt, v.input = "", ""
+	}()
+	for {
+		v.draw()
+		key, ok := <-v.keys
+		if !ok {
+			return "", false
+		}
+		switch key {
+		case "enter":
+			return strings.TrimSpace(v.input), true
+		case "esc", "ctrl-c":
+			return "", false
+		case "backspace":
+			if r := []rune(v.input); len(r) > 0 {
+				v.input = string(r[:len(r)-1])
+			}
+		case "space":
+			v.input += " "
+		default:
+			if utf8.RuneCountInString(key) == 1 {
+				v.input += key
+			}
+		}
+	}
+}
+
+func (v *tuiView) confirm(question string) bool {
+	answer, ok := v.ask(question+" [y/N] ", "")
+	return ok && (answer == "y" || answer == "Y" || answer == "yes")
+}
+
+// load lists the nodes of the node range matching the filter and fetches
+// their state.
+func (v *tuiView) load() {
+	v.message = "Loading..."
+	v.draw()
+	filters, err := utils.ParseSelector(v.where)
+	if v.where == "" {
+		filters, err = nil, nil
+	}
+	if err != nil {
+		v.message = err.Error()
+		return
+	}
+	noderange := v.noderange
+	if noderange == "all" {
+		noderange = ""
+	}
+	names, err := _list_nodes(noderange, filters)
+	if err != nil {
+		v.message = err.Error()
+		return
+	}
+	v.names = names
+	kept := make(map[string]bool)
+	for _, name := range names {
+		if v.selected[name] {
+			kept[name] = true
+		}
+	}
+	v.selected = kept
+	if v.cursor >= len(names) {
+		v.cursor = len(names) - 1
+	}
+	if v.cursor < 0 {
+		v.cursor = 0
+	}
+	v.refresh()
+}
+
+func (v *tuiView) refresh() {
+	if len(v.names) == 0 {
+		v.status = nil
+		v.message = "Could not find any record"
+		return
+	}
+	status, errs := _fetch_status(v.client, v.names)
+	v.status = status
+	v.message = fmt.Sprintf("Updated at %s", time.Now().Format("15:04:05"))
+	if len(errs) > 0 {
+		v.message = errs[0].Error()
+	}
+}
+
+// targets returns the selected nodes, or the node under the cursor when none
+// is selected.
+func (v *tuiView) targets() []string {
+	names := make([]string, 0, len(v.selected))
+	for _, name := range v.names {
+		if v.selected[name] {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 && v.cursor < len(v.names) {
+		names = append(names, v.names[v.cursor])
+	}
+	return names
+}
+
+// act asks for confirmation, runs the operation on the nodes and shows a
+// summary of the result.
+func (v *tuiView) act(label string, names []string, run func(data interface{}) (map[string]interface{}, error)) {
+	if len(names) == 0 || !v.confirm(fmt.Sprintf("%s %d node(s)?", label, len(names))) {
+		v.message = "Cancelled"
+		return
+	}
+	v.message = label + "..."
+	v.draw()
+	result, err := run(v.client.ToNodesMap(names))
+	if err != nil {
+		v.message = err.Error()
+		return
+	}
+	ok, failed := 0, make([]string, 0)
+	nodes := utils.InterfaceToMap(result["nodes"])
+	for _, name := range names {
+		if _, found := SUCCESS_RESULTS[fmt.Sprint(nodes[name])]; found {
+			ok += 1
+		} else {
+			failed = append(failed, fmt.Sprintf("%s: %v", name, nodes[name]))
+		}
+	}
+	v.refresh()
+	v.message = fmt.Sprintf("%s: %d ok, %d failed", label, ok, len(failed))
+	if len(failed) > 0 {
+		v.message += " (" + failed[0] + ")"
+	}
+}
+
+func (v *tuiView) show(name string) {
+	result, err := v.client.Show([]string{name}, nil)
+	if err != nil {
+		v.message = err.Error()
+		return
+	}
+	masked, err := utils.MaskSecretsJson(result.([]byte))
+	if err != nil {
+		v.message = err.Error()
+		return
+	}
+	var out bytes.Buffer
+	if err = json.Indent(&out, masked, "", "  "); err != nil {
+		v.message = err.Error()
+		return
+	}
+	v.title, v.detail, v.offset = name+" (esc to go back)", strings.Split(out.String(), "\n"), 0
+}
+
+func (v *tuiView) power() {
+	actions := make([]string, 0, len(allowPowerStatus))
+	for _, action := range allowPowerStatus {
+		if action != "status" {
+			actions = append(actions, action)
+		}
+	}
+	action, ok := v.ask("Power action ("+strings.Join(actions, "/")+"): ", "")
+	if !ok {
+		return
+	}
+	if exist, _ := utils.Contains(actions, action); !exist {
+		v.message = "Only allow " + strings.Join(actions, " ")
+		return
+	}
+	v.act("Power "+action, v.targets(), func(data interface{}) (map[string]interface{}, error) {
+		return v.client.Put("power", action, data)
+	})
+}
+
+func (v *tuiView) bootdev() {
+	device, ok := v.ask("Next boot device (disk/net/cdrom): ", "")
+	if !ok {
+		return
+	}
+	if exist, _ := utils.Contains(allowBootDev, device); !exist || device == "status" {
+		v.message = "Only allow disk net cdrom"
+		return
+	}
+	v.act("Set boot device "+device+" on", v.targets(), func(data interface{}) (map[string]interface{}, error) {
+		return v.client.SetBootDevice(device, false, "", data)
+	})
+}
+
+func (v *tuiView) deploy() {
+	osimage, ok := v.ask("Deploy osimage: ", "")
+	if !ok || osimage == "" {
+		return
+	}
+	osimageClient, err := NewOsimageClient()
+	if err == nil {
+		_, err = osimageClient.Show(osimage, []string{"name"}, nil, true)
+	}
+	if err != nil {
+		v.message = fmt.Sprintf("Could not find osimage %s: %s", osimage, err)
+		return
+	}
+	v.act("Deploy "+osimage+" on", v.targets(), func(data interface{}) (map[string]interface{}, error) {
+		return v.client.Deploy(osimage, "nodeset", false, data)
+	})
+}
+
+func (v *tuiView) edit() {
+	input, ok := v.ask("Update (key=val ..., key= removes): ", "")
+	if !ok || input == "" {
+		return
+	}
+	args, err := utils.SplitArgs(input)
+	if err != nil {
+		v.message = err.Error()
+		return
+	}
+	patches, err := _update_patches(args)
+	if err != nil {
+		v.message = err.Error()
+		return
+	}
+	v.act("Update", v.targets(), func(data interface{}) (map[string]interface{}, error) {
+		utils.InterfaceToMap(data)["patches"] = patches
+		return v.client.Patch("", data)
+	})
+}
+
+// handle runs the action of a key in the list view and returns false to
+// quit.
+func (v *tuiView) handle(key string) bool {
+	if v.detail != nil {
+		switch key {
+		case "esc", "q", "enter", "left":
+			v.detail, v.offset = nil, 0
+		case "up", "k":
+			v.offset--
+		case "down", "j":
+			v.offset++
+		case "pgup":
+			v.offset -= 10
+		case "pgdown":
+			v.offset += 10
+		case "ctrl-c":
+			return false
+		}
+		return true
+	}
+	switch key {
+	case "q", "ctrl-c":
+		return false
+	case "up", "k":
+		v.cursor--
+	case "down", "j":
+		v.cursor++
+	case "pgup":
+		v.cursor -= 10
+	case "pgdown":
+		v.cursor += 10
+	case "home", "g":
+		v.cursor = 0
+	case "end", "G":
+		v.cursor = len(v.names) - 1
+	case "space":
+		if v.cursor < len(v.names) {
+			name := v.names[v.cursor]
+			if v.selected[name] {
+				delete(v.selected, name)
+			} else {
+				v.selected[name] = true
+			}
+			v.cursor++
+		}
+	case "a":
+		if len(v.selected) == len(v.names) {
+			v.selected = make(map[string]bool)
+		} else {
+			for _, name := range v.names {
+				v.selected[name] = true
+			}
+		}
+	case "enter", "right":
+		if v.cursor < len(v.names) {
+			v.show(v.names[v.cursor])
+		}
+	case "/":
+		if noderange, ok := v.ask("Node range: ", v.noderange); ok {
+			v.noderange = noderange
+			v.load()
+		}
+	case "f":
+		if where, ok := v.ask("Filter (like arch=x86_64,mgt!=kvm): ", v.where); ok {
+			v.where = where
+			v.load()
+		}
+	case "r":
+		v.load()
+	case "p":
+		v.power()
+	case "b":
+		v.bootdev()
+	case "d":
+		v.deploy()
+	case "e":
+		v.edit()
+	}
+	if v.cursor >= len(v.names) {
+		v.cursor = len(v.names) - 1
+	}
+	if v.cursor < 0 {
+		v.cursor = 0
+	}
+	return true
+}
+
+func Tui(cmd *cobra.Command, args []string) {
+	if !utils.IsTerminal(os.Stdin) || !utils.IsTerminal(os.Stdout) {
+		fmt.Println("tui command should run in a terminal.")
+		os.Exit(1)
+	}
+	client, err := NewNodeClient()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	v := &tuiView{client: client, keys: make(chan string, 16), where: tuiOpts.where,
+		selected: make(map[string]bool)}
+	if len(args) > 0 {
+		v.noderange = args[0]
+	}
+	restore, err := utils.MakeRaw()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	// switch to the alternate screen so that the shell is left as it was
+	os.Stdout.WriteString("\033[?1049h\033[?25l")
+	defer func() {
+		os.Stdout.WriteString("\033[?25h\033[?1049l")
+		restore()
+	}()
+	go _read_keys(v.keys)
+	resize := make(chan os.Signal, 1)
+	signal.Notify(resize, syscall.SIGWINCH)
+	defer signal.Stop(resize)
+	v.rows, v.cols = utils.TerminalSize()
+	v.load()
+	var tick <-chan time.Time
+	if tuiOpts.refresh > 0 {
+		ticker := time.NewTicker(tuiOpts.refresh)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		v.draw()
+		select {
+		case key, ok := <-v.keys:
+			if !ok || !v.handle(key) {
+				return
+			}
+		case <-tick:
+			if v.detail == nil {
+				v.refresh()
+			}
+		case <-resize:
+			v.rows, v.cols = utils.TerminalSize()
+		}
+	}
+}
+
+func TuiCommand() *cobra.Command {
+	tuiOpts = new(TuiOptions)
+	cmd := &cobra.Command{
+		Use:   "tui [<node range>] [--where <filter>]",
+		Short: "Browse and operate on node(s) in a full-screen terminal UI.",
+		Long: `Browse and operate on node(s) in a full-screen terminal UI. Format: tui [<node range>] [--where <filter>]
+		The node(s) are listed with their power state, boot device and provision state, which are
+		refreshed every --refresh. Move with the arrow keys, select with space, show a node with
+		enter, change the node range with / and the filter with f. p, b, d and e run power,
+		bootdev, deploy and update on the selected node(s) after confirmation.`,
+		Run: Tui,
+	}
+	cmd.Flags().DurationVarP(&tuiOpts.refresh, "refresh", "", 10*time.Second,
+		`How often to refresh the state of the node(s), 0 to disable.`)
+	cmd.Flags().StringVarP(&tuiOpts.where, "where", "w", "",
+		`Only list the node(s) matching the filters, like arch=x86_64,mgt!=kvm.`)
+	_add_selector_flag(cmd)
+	return cmd
+}
+
+func init() {
+	RootCmd.AddCommand(TuiCommand())
+}
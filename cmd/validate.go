@@ -0,0 +1,350 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/chenglch/golang-xcat3client/utils"
+	"github.com/spf13/cobra"
+)
+
+type FieldSpec struct {
+	// Kind is one of string, enum, bool, mac, ip, netmask or map.
+	Kind   string
+	Values []string
+}
+
+// NodeSchema describes the node attributes accepted by a version of the
+// xCAT3 API. Nodes are checked against it before any request is sent.
+type NodeSchema struct {
+	Version string
+	Fields  map[string]FieldSpec
+	Nic     map[string]FieldSpec
+	Control map[string]FieldSpec
+	// Required lists the control_info keys needed by each mgt type.
+	Required map[string][]string
+}
+
+const CURRENT_SCHEMA_VERSION = "1.0"
+
+var NODE_SCHEMAS = map[string]*NodeSchema{
+	"1.0": {
+		Version: "1.0",
+		Fields: map[string]FieldSpec{
+			"name":         {Kind: "string"},
+			"uuid":         {Kind: "string"},
+			"type":         {Kind: "string"},
+			"groups":       {Kind: "string"},
+			"arch":         {Kind: "enum", Values: []string{"x86_64", "ppc64", "ppc64le", "aarch64"}},
+			"netboot":      {Kind: "enum", Values: []string{"pxe", "grub2", "petitboot", "yaboot"}},
+			"mgt":          {Kind: "enum", Values: []string{"ipmi", "openbmc", "kvm"}},
+			"nics_info":    {Kind: "map"},
+			"control_info": {Kind: "map"},
+			"extra":        {Kind: "map"},
+		},
+		Nic: map[string]FieldSpec{
+			"uuid":    {Kind: "string"},
+			"name":    {Kind: "string"},
+			"mac":     {Kind: "mac"},
+			"ip":      {Kind: "ip"},
+			"netmask": {Kind: "netmask"},
+			"primary": {Kind: "bool"},
+			"extra":   {Kind: "map"},
+		},
+		Control: map[string]FieldSpec{
+			"bmc_address":  {Kind: "ip"},
+			"bmc_username": {Kind: "string"},
+			"bmc_password": {Kind: "string"},
+		},
+		Required: map[string][]string{
+			"ipmi":    {"bmc_address", "bmc_username", "bmc_password"},
+			"openbmc": {"bmc_address", "bmc_username", "bmc_password"},
+		},
+	},
+}
+
+func GetNodeSchema(version string) (*NodeSchema, error) {
+	if version == "" {
+		version = CURRENT_SCHEMA_VERSION
+	}
+	schema, ok := NODE_SCHEMAS[version]
+	if !ok {
+		return nil, fmt.Errorf("Unknown schema version %s.", version)
+	}
+	return schema, nil
+}
+
+func (spec FieldSpec) check(value interface{}) error {
+	if value == nil {
+		// the server returns null for the attributes not set
+		return nil
+	}
+	if spec.Kind == "map" {
+		if _, ok := value.(map[string]interface{}); !ok {
+			return errors.New("should be a map")
+		}
+		return nil
+	}
+	var s string
+	switch v := value.(type) {
+	case string:
+		s = v
+	case bool:
+		if spec.Kind != "bool" && spec.Kind != "string" {
+			return fmt.Errorf("should be a %s", spec.Kind)
+		}
+		return nil
+	case int, int64, float64, json.Number:
+		// the numbers typed with key:int= or read from json
+		if spec.Kind == "string" {
+			return nil
+		}
+		if spec.Kind != "netmask" {
+			return fmt.Errorf("should be a %s", spec.Kind)
+		}
+		s = fmt.Sprint(v)
+	default:
+		return fmt.Errorf("should be a %s", spec.Kind)
+	}
+	switch spec.Kind {
+	case "enum":
+		if exist, _ := utils.Contains(spec.Values, s); !exist {
+			return fmt.Errorf("'%s' is not one of %s", s, strings.Join(spec.Values, ", "))
+		}
+	case "bool":
+		if _, err := strconv.ParseBool(s); err != nil {
+			return fmt.Errorf("'%s' is not a boolean", s)
+		}
+	case "mac":
+		if mac, err := net.ParseMAC(s); err != nil || len(mac) != 6 {
+			return fmt.Errorf("'%s' is not a MAC address", s)
+		}
+	case "ip":
+		if net.ParseIP(s) == nil {
+			return fmt.Errorf("'%s' is not an IP address", s)
+		}
+	case "netmask":
+		if prefix, err := strconv.Atoi(s); err == nil {
+			if prefix < 0 || prefix > 128 {
+				return fmt.Errorf("'%s' is not a netmask", s)
+			}
+			return nil
+		}
+		ip := net.ParseIP(s).To4()
+		if ip == nil {
+			return fmt.Errorf("'%s' is not a netmask", s)
+		}
+		if ones, bits := net.IPMask(ip).Size(); ones == 0 && bits == 0 {
+			return fmt.Errorf("'%s' is not a netmask", s)
+		}
+	}
+	return nil
+}
+
+func _unknown_field(key string, specs map[string]FieldSpec) error {
+	best, dist := "", 3
+	for name := range specs {
+		if d := _edit_distance(key, name); d < dist {
+			best, dist = name, d
+		}
+	}
+	if best != "" {
+		return fmt.Errorf("%s: unknown attribute, did you mean %s?", key, best)
+	}
+	return fmt.Errorf("%s: unknown attribute", key)
+}
+
+func _edit_distance(a string, b string) int {
+	row := make([]int, len(b)+1)
+	for j := range row {
+		row[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		prev := row[0]
+		row[0] = i
+		for j := 1; j <= len(b); j++ {
+			cur := row[j]
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			row[j] = prev + cost
+			if row[j-1]+1 < row[j] {
+				row[j] = row[j-1] + 1
+			}
+			if cur+1 < row[j] {
+				row[j] = cur + 1
+			}
+			prev = cur
+		}
+	}
+	return row[len(b)]
+}
+
+func _check_fields(prefix string, m map[string]interface{}, specs map[string]FieldSpec) []error {
+	var errs []error
+	for key, value := range m {
+		spec, ok := specs[key]
+		if !ok {
+			errs = append(errs, fmt.Errorf("%s%s", prefix, _unknown_field(key, specs)))
+			continue
+		}
+		if err := spec.check(value); err != nil {
+			errs = append(errs, fmt.Errorf("%s%s: %s", prefix, key, err))
+		}
+	}
+	return errs
+}
+
+// ValidateNode checks the attributes of a node to be created.
+func (schema *NodeSchema) ValidateNode(node map[string]interface{}) []error {
+	errs := _check_fields("", node, schema.Fields)
+	if _, ok := node["name"]; !ok {
+		errs = append(errs, errors.New("name: missing"))
+	}
+	control, _ := node["control_info"].(map[string]interface{})
+	if control != nil {
+		for key, value := range control {
+			if spec, ok := schema.Control[key]; ok {
+				if err := spec.check(value); err != nil {
+					errs = append(errs, fmt.Errorf("control_info.%s: %s", key, err))
+				}
+			}
+		}
+	}
+	if mgt, ok := node["mgt"].(string); ok {
+		for _, key := range schema.Required[mgt] {
+			if _, ok := control[key]; !ok {
+				errs = append(errs, fmt.Errorf("control_info.%s: required by mgt %s", key, mgt))
+			}
+		}
+	}
+	if nics_info, ok := node["nics_info"].(map[string]interface{}); ok {
+		nics, ok := nics_info["nics"].([]interface{})
+		if !ok && nics_info["nics"] != nil {
+			errs = append(errs, errors.New("nics_info.nics: should be a list"))
+		}
+		for i, nic := range nics {
+			nic_map, ok := nic.(map[string]interface{})
+			if !ok {
+				errs = append(errs, fmt.Errorf("nics_info.nics[%d]: should be a map", i))
+				continue
+			}
+			errs = append(errs, _check_fields(fmt.Sprintf("nics_info.nics[%d].", i), nic_map, schema.Nic)...)
+		}
+	}
+	return errs
+}
+
+// ValidateNodes checks every node and reports all the problems at once.
+func (schema *NodeSchema) ValidateNodes(nodes []interface{}) error {
+	var lines []string
+	for i, node := range nodes {
+		node_map, ok := node.(map[string]interface{})
+		if !ok {
+			lines = append(lines, fmt.Sprintf("nodes[%d]: should be a map", i))
+			continue
+		}
+		name, ok := node_map["name"].(string)
+		if !ok {
+			name = fmt.Sprintf("nodes[%d]", i)
+		}
+		errs := schema.ValidateNode(node_map)
+		for _, err := range errs {
+			lines = append(lines, fmt.Sprintf("%s: %s", name, err))
+		}
+	}
+	if len(lines) > 0 {
+		sort.Strings(lines)
+		return errors.New("Validation failed:\n" + strings.Join(lines, "\n"))
+	}
+	return nil
+}
+
+// ValidatePatches checks the patches of the update command.
+func (schema *NodeSchema) ValidatePatches(patches []map[string]interface{}) error {
+	var lines []string
+	for _, patch := range patches {
+		items := strings.Split(strings.TrimPrefix(patch["path"].(string), "/"), "/")
+		spec, ok := schema.Fields[items[0]]
+		if !ok {
+			lines = append(lines, _unknown_field(items[0], schema.Fields).Error())
+			continue
+		}
+		if patch["op"] == "remove" {
+			continue
+		}
+		if len(items) == 1 {
+			if spec.Kind == "map" {
+				lines = append(lines, fmt.Sprintf("%s: should be a map", items[0]))
+			} else if err := spec.check(patch["value"]); err != nil {
+				lines = append(lines, fmt.Sprintf("%s: %s", items[0], err))
+			}
+		} else if items[0] == "control_info" {
+			if spec, ok := schema.Control[items[1]]; ok {
+				if err := spec.check(patch["value"]); err != nil {
+					lines = append(lines, fmt.Sprintf("%s: %s", strings.Join(items, "."), err))
+				}
+			}
+		}
+	}
+	if len(lines) > 0 {
+		return errors.New("Validation failed:\n" + strings.Join(lines, "\n"))
+	}
+	return nil
+}
+
+// _validate_import checks the content of an import file. The optional
+// schema_version key selects the schema and is removed from the data.
+func _validate_import(data map[string]interface{}) error {
+	version, _ := data["schema_version"].(string)
+	delete(data, "schema_version")
+	schema, err := GetNodeSchema(version)
+	if err != nil {
+		return err
+	}
+	nodes, ok := data["nodes"].([]interface{})
+	if !ok {
+		return errors.New("Could not find the nodes list in the file.")
+	}
+	return schema.ValidateNodes(nodes)
+}
+
+func ValidateFile(cmd *cobra.Command, args []string) {
+	if len(args) != 1 {
+		fmt.Println("validate command should accept a json file as the argument.")
+		os.Exit(1)
+	}
+	data, err := utils.ReadJsonFile(args[0])
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if err := _validate_import(data); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	fmt.Printf("%s: %d node(s) valid\n", args[0], len(data["nodes"].([]interface{})))
+}
+
+func ValidateCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "validate <json file>",
+		Short: "Check node(s) information of a json data file without contacting the server.",
+		Long: `Check node(s) information of a json data file without contacting the server.
+		Format: validate <json file>. The file uses the format of the import command, an optional
+		"schema_version" key selects the version of the node schema.`,
+		Run: ValidateFile,
+	}
+	return cmd
+}
+
+func init() {
+	RootCmd.AddCommand(ValidateCommand())
+}
@@ -0,0 +1,179 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/chenglch/golang-xcat3client/utils"
+)
+
+// NodeProgress is the state of a node while waiting for an operation.
+// Result is empty while the node is pending, then done or failed, or
+// timeout when the node did not finish in time.
+type NodeProgress struct {
+	State   string
+	Elapsed time.Duration
+	Result  string
+}
+
+// _wait_nodes polls the state of the nodes until the check function returns
+// a result for every node or the timeout expires. check is called with the
+// name and the state of each pending node after each poll. fetch is called with the
+// nodes still pending and returns their current state. The refresh function,
+// if any, is called after each poll.
+func _wait_nodes(names []string, fetch func([]string) (map[string]string, error),
+	check func(string, string) string, timeout time.Duration, interval time.Duration,
+	refresh func(map[string]*NodeProgress)) map[string]*NodeProgress {
+	progress := make(map[string]*NodeProgress, len(names))
+	for _, name := range names {
+		progress[name] = &NodeProgress{State: "unknown"}
+	}
+	start := time.Now()
+	pending := names
+	for len(pending) > 0 {
+		states, err := fetch(pending)
+		if err != nil {
+			fmt.Println(err)
+		}
+		left := make([]string, 0, len(pending))
+		for _, name := range pending {
+			p := progress[name]
+			if state, ok := states[name]; ok {
+				p.State = state
+			}
+			p.Elapsed = time.Since(start)
+			if p.Result = check(name, p.State); p.Result == "" {
+				left = append(left, name)
+			}
+		}
+		pending = left
+		if len(pending) > 0 && time.Since(start)+interval > timeout {
+			for _, name := range pending {
+				progress[name].Result = "timeout"
+			}
+			pending = nil
+		}
+		if refresh != nil {
+			refresh(progress)
+		}
+		if len(pending) > 0 {
+			time.Sleep(interval)
+		}
+	}
+	return progress
+}
+
+// _live_table returns a refresh function for _wait_nodes which redraws the
+// table of the nodes in place, or nil when the output is not a terminal.
+// When the table does not fit in the terminal, the pending nodes are shown
+// first with a summary line, as the rows scrolled away could not be redrawn.
+func _live_table(names []string) func(map[string]*NodeProgress) {
+	if !utils.IsTerminal(os.Stdout) {
+		return nil
+	}
+	rows, _ := utils.TerminalSize()
+	// the header, the summary and the line of the cursor
+	limit := rows - 3
+	if limit < 1 {
+		limit = 1
+	}
+	drawn := 0
+	return func(progress map[string]*NodeProgress) {
+		if drawn > 0 {
+			// move the cursor back to the first row
+			fmt.Printf("\033[%dA", drawn)
+		}
+		shown := names
+		summary := ""
+		if len(names) > rows-2 {
+			shown = _pending_first(names, progress, limit)
+			counts := make(map[string]int)
+			for _, name := range names {
+				counts[progress[name].Result] += 1
+			}
+			summary = fmt.Sprintf("%d of %d node(s) shown, pending: %d done: %d failed: %d timeout: %d",
+				len(shown), len(names), counts[""], counts["done"], counts["failed"], counts["timeout"])
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(w, "NODE\tSTATE\tELAPSED\t\033[K")
+		for _, name := range shown {
+			p := progress[name]
+			fmt.Fprintf(w, "%s\t%s\t%s\t\033[K\n", name, p.State, p.Elapsed.Truncate(time.Second))
+		}
+		w.Flush()
+		drawn = len(shown) + 1
+		if summary != "" {
+			fmt.Printf("%s\033[K\n", summary)
+			drawn += 1
+		}
+	}
+}
+
+// _pending_first returns at most limit nodes, the pending ones first, in the
+// order of names.
+func _pending_first(names []string, progress map[string]*NodeProgress, limit int) []string {
+	picked := make(map[string]bool, limit)
+	for _, pending := range []bool{true, false} {
+		for _, name := range names {
+			if len(picked) < limit && (progress[name].Result == "") == pending {
+				picked[name] = true
+			}
+		}
+	}
+	shown := make([]string, 0, len(picked))
+	for _, name := range names {
+		if picked[name] {
+			shown = append(shown, name)
+		}
+	}
+	return shown
+}
+
+// _print_progress_table prints one row per node with the final state and the
+// time the node took, and returns the number of nodes not done.
+func _print_progress_table(names []string, progress map[string]*NodeProgress) int {
+	failed := 0
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "NODE\tSTATE\tELAPSED\tRESULT")
+	for _, name := range names {
+		p := progress[name]
+		if p.Result != "done" {
+			failed += 1
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", name, p.State, p.Elapsed.Truncate(time.Second), p.Result)
+	}
+	w.Flush()
+	fmt.Printf("\nSuccess: %d Failed: %d\n", len(names)-failed, failed)
+	return failed
+}
+
+// _node_states gets the state of the nodes from a resource like power.
+func _node_states(client *NodeClient, resource string, names []string) (map[string]string, error) {
+	states, _, err := _node_state_changes(client, resource, names)
+	return states, err
+}
+
+// _node_state_changes gets the state of the nodes from a resource like power,
+// and the time of the last change of the state for the nodes the server
+// reports it for, as {"state": "on", "changed_at": "..."}.
+func _node_state_changes(client *NodeClient, resource string, names []string) (map[string]string, map[string]string, error) {
+	result, err := client.Get(resource, client.ToNodesMap(names))
+	if err != nil {
+		return nil, nil, err
+	}
+	states := make(map[string]string)
+	changes := make(map[string]string)
+	for k, v := range utils.InterfaceToMap(utils.InterfaceToMap(result)["nodes"]) {
+		if m, ok := v.(map[string]interface{}); ok {
+			states[k] = fmt.Sprint(m["state"])
+			if changed, ok := m["changed_at"].(string); ok {
+				changes[k] = changed
+			}
+			continue
+		}
+		states[k] = fmt.Sprint(v)
+	}
+	return states, changes, nil
+}
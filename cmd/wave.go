@@ -0,0 +1,140 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/chenglch/golang-xcat3client/utils"
+	"github.com/spf13/cobra"
+)
+
+// WaveOptions splits an operation on many nodes into waves, so that power
+// on thousands of nodes does not trip the PDUs.
+type WaveOptions struct {
+	size     int
+	interval time.Duration
+	perRack  int
+	// maxFailure is the failure rate of a wave above which the next waves are
+	// not started.
+	maxFailure float64
+}
+
+func _add_wave_flags(cmd *cobra.Command, opts *WaveOptions) {
+	cmd.Flags().IntVarP(&opts.size, "wave-size", "", 0,
+		`Operate on at most this number of node(s) at a time.`)
+	cmd.Flags().DurationVarP(&opts.interval, "wave-interval", "", 30*time.Second,
+		`Time to wait between two waves.`)
+	cmd.Flags().IntVarP(&opts.perRack, "max-per-rack", "", 0,
+		`Operate on at most this number of node(s) of the same rack at a time. The rack is
+		the rack label of the node, see the label command.`)
+	cmd.Flags().Float64VarP(&opts.maxFailure, "max-failure-rate", "", 1,
+		`Stop when the failure rate of a wave is above this value, like 0.1.`)
+}
+
+func (opts *WaveOptions) enabled() bool {
+	return opts.size > 0 || opts.perRack > 0
+}
+
+// _make_waves splits the nodes into waves. With perRack the racks are served
+// in turn so that each wave holds at most perRack node(s) of a rack.
+func _make_waves(names []string, opts *WaveOptions) ([][]string, error) {
+	if len(names) == 0 {
+		return nil, fmt.Errorf("No node to split into waves.")
+	}
+	size := opts.size
+	if size <= 0 {
+		size = len(names)
+	}
+	if opts.perRack <= 0 {
+		waves := make([][]string, 0, len(names)/size+1)
+		for start := 0; start < len(names); start += size {
+			end := start + size
+			if end > len(names) {
+				end = len(names)
+			}
+			waves = append(waves, names[start:end])
+		}
+		return waves, nil
+	}
+	client, err := NewNodeClient()
+	if err != nil {
+		return nil, err
+	}
+	wanted := make(map[string]bool, len(names))
+	for _, name := range names {
+		wanted[name] = true
+	}
+	nodes, err := _node_attrs(client, []string{"extra"}, nil, wanted)
+	if err != nil {
+		return nil, err
+	}
+	rackOf := make(map[string]string, len(nodes))
+	for _, node := range nodes {
+		rack, _ := utils.Lookup(node, []string{"extra", "rack"})
+		rackOf[node["name"].(string)] = fmt.Sprint(rack)
+	}
+	racks := make([]string, 0)
+	queues := make(map[string][]string)
+	for _, name := range names {
+		rack := rackOf[name]
+		if _, ok := queues[rack]; !ok {
+			racks = append(racks, rack)
+		}
+		queues[rack] = append(queues[rack], name)
+	}
+	waves := make([][]string, 0)
+	for left := len(names); left > 0; {
+		wave := make([]string, 0, size)
+		for _, rack := range racks {
+			n := opts.perRack
+			if n > len(queues[rack]) {
+				n = len(queues[rack])
+			}
+			if n > size-len(wave) {
+				n = size - len(wave)
+			}
+			wave = append(wave, queues[rack][:n]...)
+			queues[rack] = queues[rack][n:]
+		}
+		left -= len(wave)
+		waves = append(waves, wave)
+	}
+	return waves, nil
+}
+
+// _run_waves runs the operation on each wave in order, prints the result of
+// each wave and returns all the results. It stops early when the failure rate
+// of a wave is above the limit.
+func _run_waves(waves [][]string, opts *WaveOptions, run func([]string) (map[string]interface{}, error)) map[string]interface{} {
+	result := map[string]interface{}{"nodes": make(map[string]interface{})}
+	for i, wave := range waves {
+		if len(waves) > 1 {
+			if i > 0 {
+				time.Sleep(opts.interval)
+			}
+			fmt.Printf("Wave %d/%d: %d node(s)\n", i+1, len(waves), len(wave))
+		}
+		ret, err := run(wave)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		_print_node_result(ret)
+		failed := 0
+		for _, v := range utils.InterfaceToMap(ret["nodes"]) {
+			if _, ok := SUCCESS_RESULTS[fmt.Sprint(v)]; !ok {
+				failed += 1
+			}
+		}
+		utils.MergeMap(result["nodes"].(map[string]interface{}), utils.InterfaceToMap(ret["nodes"]))
+		if rate := float64(failed) / float64(len(wave)); rate > opts.maxFailure && i < len(waves)-1 {
+			fmt.Printf("\nStopped after wave %d/%d: failure rate %.2f is above %.2f\n", i+1, len(waves), rate, opts.maxFailure)
+			os.Exit(1)
+		}
+		if len(waves) > 1 {
+			fmt.Println()
+		}
+	}
+	return result
+}
@@ -48,7 +48,7 @@ func (client *XCAT3Client) Patch(url string, data interface{}, retJson bool) (in
 	} else {
 		url = client.Resource
 	}
-	result, err := client.Sess.Patch(url, nil, data, false)
+	result, err := client.Sess.Patch(url, nil, data, retJson)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,340 @@
+package utils
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// DialConsole connects to the console proxy at the url. ws and wss urls are
+// websocket proxies, telnet urls are telnet servers and tcp urls are plain
+// sockets.
+func DialConsole(rawurl string, headers http.Header) (io.ReadWriteCloser, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "ws", "wss":
+		return dialWebsocket(u, headers)
+	case "telnet":
+		conn, err := net.Dial("tcp", hostPort(u, "23"))
+		if err != nil {
+			return nil, err
+		}
+		return &telnetConn{conn: conn, br: bufio.NewReader(conn)}, nil
+	case "tcp":
+		return net.Dial("tcp", u.Host)
+	}
+	return nil, fmt.Errorf("Unsupported console url %s, expected ws, wss, telnet or tcp.", rawurl)
+}
+
+func hostPort(u *url.URL, port string) string {
+	if u.Port() != "" {
+		return u.Host
+	}
+	return net.JoinHostPort(u.Hostname(), port)
+}
+
+// telnetConn strips the telnet commands from the data and answers the
+// option negotiation, accepting only echo and suppress go ahead.
+type telnetConn struct {
+	conn net.Conn
+	br   *bufio.Reader
+	lock sync.Mutex
+}
+
+const (
+	telnetIAC  = 255
+	telnetDONT = 254
+	telnetDO   = 253
+	telnetWONT = 252
+	telnetWILL = 251
+	telnetSB   = 250
+	telnetSE   = 240
+	telnetECHO = 1
+	telnetSGA  = 3
+)
+
+func (t *telnetConn) command(cmd byte, opt byte) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	_, err := t.conn.Write([]byte{telnetIAC, cmd, opt})
+	return err
+}
+
+func (t *telnetConn) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		if n > 0 && t.br.Buffered() == 0 {
+			break
+		}
+		b, err := t.br.ReadByte()
+		if err != nil {
+			if n > 0 {
+				break
+			}
+			return 0, err
+		}
+		if b != telnetIAC {
+			p[n] = b
+			n++
+			continue
+		}
+		cmd, err := t.br.ReadByte()
+		if err != nil {
+			return n, err
+		}
+		switch cmd {
+		case telnetIAC:
+			p[n] = telnetIAC
+			n++
+		case telnetDO, telnetDONT, telnetWILL, telnetWONT:
+			opt, err := t.br.ReadByte()
+			if err != nil {
+				return n, err
+			}
+			if cmd == telnetWILL && (opt == telnetECHO || opt == telnetSGA) {
+				err = t.command(telnetDO, opt)
+			} else if cmd == telnetWILL {
+				err = t.command(telnetDONT, opt)
+			} else if cmd == telnetDO {
+				err = t.command(telnetWONT, opt)
+			}
+			if err != nil {
+				return n, err
+			}
+		case telnetSB:
+			// skip the sub negotiation up to IAC SE
+			for prev := byte(0); ; {
+				b, err := t.br.ReadByte()
+				if err != nil {
+					return n, err
+				}
+				if prev == telnetIAC && b == telnetSE {
+					break
+				}
+				prev = b
+			}
+		}
+	}
+	return n, nil
+}
+
+func (t *telnetConn) Write(p []byte) (int, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	escaped := make([]byte, 0, len(p))
+	for _, b := range p {
+		if b == telnetIAC {
+			escaped = append(escaped, telnetIAC)
+		}
+		escaped = append(escaped, b)
+	}
+	if _, err := t.conn.Write(escaped); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (t *telnetConn) Close() error {
+	return t.conn.Close()
+}
+
+// wsConn is a minimal websocket client as described in RFC 6455. The data is
+// sent in binary frames and the payload of the data frames is returned by
+// Read.
+type wsConn struct {
+	conn    net.Conn
+	br      *bufio.Reader
+	pending []byte
+	lock    sync.Mutex
+}
+
+const (
+	wsGUID         = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+	wsContinuation = 0
+	wsText         = 1
+	wsBinary       = 2
+	wsClose        = 8
+	wsPing         = 9
+	wsPong         = 10
+)
+
+func dialWebsocket(u *url.URL, headers http.Header) (*wsConn, error) {
+	var conn net.Conn
+	var err error
+	if u.Scheme == "wss" {
+		conn, err = tls.Dial("tcp", hostPort(u, "443"), &tls.Config{ServerName: u.Hostname()})
+	} else {
+		conn, err = net.Dial("tcp", hostPort(u, "80"))
+	}
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, 16)
+	if _, err = rand.Read(nonce); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	key := base64.StdEncoding.EncodeToString(nonce)
+	path := u.RequestURI()
+	request := fmt.Sprintf("GET %s HTTP/1.1\r\nHost: %s\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"+
+		"Sec-WebSocket-Key: %s\r\nSec-WebSocket-Version: 13\r\n", path, u.Host, key)
+	for k := range headers {
+		request += fmt.Sprintf("%s: %s\r\n", k, headers.Get(k))
+	}
+	if _, err = conn.Write([]byte(request + "\r\n")); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, &http.Request{Method: "GET"})
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	sum := sha1.Sum([]byte(key + wsGUID))
+	if resp.StatusCode != http.StatusSwitchingProtocols ||
+		resp.Header.Get("Sec-WebSocket-Accept") != base64.StdEncoding.EncodeToString(sum[:]) {
+		conn.Close()
+		return nil, fmt.Errorf("Could not open the websocket %s: %s", u.String(), resp.Status)
+	}
+	return &wsConn{conn: conn, br: br}, nil
+}
+
+func (ws *wsConn) writeFrame(opcode byte, payload []byte) error {
+	ws.lock.Lock()
+	defer ws.lock.Unlock()
+	frame := []byte{0x80 | opcode}
+	switch n := len(payload); {
+	case n < 126:
+		frame = append(frame, 0x80|byte(n))
+	case n < 65536:
+		frame = append(frame, 0x80|126, byte(n>>8), byte(n))
+	default:
+		frame = append(frame, 0x80|127)
+		size := make([]byte, 8)
+		binary.BigEndian.PutUint64(size, uint64(n))
+		frame = append(frame, size...)
+	}
+	// the frames of a client are always masked
+	mask := make([]byte, 4)
+	if _, err := rand.Read(mask); err != nil {
+		return err
+	}
+	frame = append(frame, mask...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	_, err := ws.conn.Write(frame)
+	return err
+}
+
+func (ws *wsConn) readFrame() (byte, []byte, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(ws.br, header); err != nil {
+		return 0, nil, err
+	}
+	opcode := header[0] & 0x0f
+	size := uint64(header[1] & 0x7f)
+	switch size {
+	case 126:
+		ext := make([]byte, 2)
+		if _, err := io.ReadFull(ws.br, ext); err != nil {
+			return 0, nil, err
+		}
+		size = uint64(binary.BigEndian.Uint16(ext))
+	case 127:
+		ext := make([]byte, 8)
+		if _, err := io.ReadFull(ws.br, ext); err != nil {
+			return 0, nil, err
+		}
+		size = binary.BigEndian.Uint64(ext)
+	}
+	var mask []byte
+	if header[1]&0x80 != 0 {
+		mask = make([]byte, 4)
+		if _, err := io.ReadFull(ws.br, mask); err != nil {
+			return 0, nil, err
+		}
+	}
+	if size > 1<<24 {
+		return 0, nil, errors.New("Websocket frame is too large.")
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(ws.br, payload); err != nil {
+		return 0, nil, err
+	}
+	if mask != nil {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+	return opcode, payload, nil
+}
+
+func (ws *wsConn) Read(p []byte) (int, error) {
+	for len(ws.pending) == 0 {
+		opcode, payload, err := ws.readFrame()
+		if err != nil {
+			return 0, err
+		}
+		switch opcode {
+		case wsText, wsBinary, wsContinuation:
+			ws.pending = payload
+		case wsPing:
+			if err = ws.writeFrame(wsPong, payload); err != nil {
+				return 0, err
+			}
+		case wsClose:
+			ws.writeFrame(wsClose, nil)
+			return 0, io.EOF
+		}
+	}
+	n := copy(p, ws.pending)
+	ws.pending = ws.pending[n:]
+	return n, nil
+}
+
+func (ws *wsConn) Write(p []byte) (int, error) {
+	if err := ws.writeFrame(wsBinary, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (ws *wsConn) Close() error {
+	ws.writeFrame(wsClose, nil)
+	return ws.conn.Close()
+}
+
+// ConsoleURL resolves the url of a console proxy returned by the server,
+// which may be relative to the url of the service.
+func ConsoleURL(rawurl string) (string, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme != "" && u.Host != "" {
+		return rawurl, nil
+	}
+	base, err := url.Parse(XCAT3_URL)
+	if err != nil {
+		return "", err
+	}
+	u = base.ResolveReference(u)
+	u.Scheme = strings.Replace(strings.Replace(u.Scheme, "https", "wss", 1), "http", "ws", 1)
+	return u.String(), nil
+}
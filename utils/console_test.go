@@ -0,0 +1,184 @@
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// serveOnce accepts one connection on a local port and runs handle on it.
+// The error of handle is sent to the returned channel.
+func serveOnce(t *testing.T, handle func(conn net.Conn) error) (string, <-chan error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		defer l.Close()
+		conn, err := l.Accept()
+		if err != nil {
+			done <- err
+			return
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		done <- handle(conn)
+	}()
+	return l.Addr().String(), done
+}
+
+// readClientFrame reads a frame sent by the client, which must be masked, and
+// returns its opcode and unmasked payload.
+func readClientFrame(br *bufio.Reader) (byte, []byte, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(br, header); err != nil {
+		return 0, nil, err
+	}
+	if header[1]&0x80 == 0 {
+		return 0, nil, fmt.Errorf("frame of the client is not masked")
+	}
+	size := uint64(header[1] & 0x7f)
+	switch size {
+	case 126:
+		ext := make([]byte, 2)
+		if _, err := io.ReadFull(br, ext); err != nil {
+			return 0, nil, err
+		}
+		size = uint64(binary.BigEndian.Uint16(ext))
+	case 127:
+		ext := make([]byte, 8)
+		if _, err := io.ReadFull(br, ext); err != nil {
+			return 0, nil, err
+		}
+		size = binary.BigEndian.Uint64(ext)
+	}
+	mask := make([]byte, 4)
+	if _, err := io.ReadFull(br, mask); err != nil {
+		return 0, nil, err
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(br, payload); err != nil {
+		return 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return header[0] & 0x0f, payload, nil
+}
+
+// serverFrame returns an unmasked frame as sent by a server.
+func serverFrame(opcode byte, payload []byte) []byte {
+	return append([]byte{0x80 | opcode, byte(len(payload))}, payload...)
+}
+
+// wsEcho is a websocket console stand-in which pings the client before
+// echoing the first data frame, and expects the pong in between.
+func wsEcho(conn net.Conn) error {
+	br := bufio.NewReader(conn)
+	req, err := http.ReadRequest(br)
+	if err != nil {
+		return err
+	}
+	sum := sha1.Sum([]byte(req.Header.Get("Sec-WebSocket-Key") + wsGUID))
+	fmt.Fprintf(conn, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"+
+		"Sec-WebSocket-Accept: %s\r\n\r\n", base64.StdEncoding.EncodeToString(sum[:]))
+	opcode, data, err := readClientFrame(br)
+	if err != nil {
+		return err
+	}
+	if opcode != wsBinary {
+		return fmt.Errorf("opcode %d, expected a binary frame", opcode)
+	}
+	conn.Write(serverFrame(wsPing, []byte("hb")))
+	conn.Write(serverFrame(wsText, data))
+	opcode, payload, err := readClientFrame(br)
+	if err != nil {
+		return err
+	}
+	if opcode != wsPong || string(payload) != "hb" {
+		return fmt.Errorf("got opcode %d with %q, expected the pong of the ping", opcode, payload)
+	}
+	conn.Write(serverFrame(wsClose, nil))
+	opcode, _, err = readClientFrame(br)
+	if err == nil && opcode != wsClose {
+		err = fmt.Errorf("opcode %d, expected the close frame", opcode)
+	}
+	return err
+}
+
+func TestWebsocketConsole(t *testing.T) {
+	addr, done := serveOnce(t, wsEcho)
+	console, err := DialConsole("ws://"+addr+"/v1/nodes/node1/console", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer console.Close()
+	if _, err = console.Write([]byte("hello\r")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 64)
+	n, err := console.Read(buf)
+	if err != nil || string(buf[:n]) != "hello\r" {
+		t.Fatalf("read %q, %v, expected the echo", buf[:n], err)
+	}
+	if _, err = console.Read(buf); err != io.EOF {
+		t.Fatalf("read %v after the close frame, expected EOF", err)
+	}
+	if err = <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+// telnetEcho is a telnet console stand-in which negotiates some options, then
+// sends data holding an escaped IAC and checks the answers of the client.
+func telnetEcho(conn net.Conn) error {
+	conn.Write([]byte{telnetIAC, telnetWILL, telnetECHO, telnetIAC, telnetWILL, 99,
+		telnetIAC, telnetDO, 24, telnetIAC, telnetSB, 24, 1, telnetIAC, telnetSE,
+		'a', telnetIAC, telnetIAC, 'b'})
+	expected := []byte{telnetIAC, telnetDO, telnetECHO, telnetIAC, telnetDONT, 99, telnetIAC, telnetWONT, 24,
+		'x', telnetIAC, telnetIAC, 'y'}
+	got := make([]byte, len(expected))
+	if _, err := io.ReadFull(conn, got); err != nil {
+		return err
+	}
+	if !bytes.Equal(got, expected) {
+		return fmt.Errorf("got %v from the client, expected %v", got, expected)
+	}
+	return nil
+}
+
+func TestTelnetConsole(t *testing.T) {
+	addr, done := serveOnce(t, telnetEcho)
+	console, err := DialConsole("telnet://"+addr, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer console.Close()
+	var data []byte
+	buf := make([]byte, 64)
+	for len(data) < 3 {
+		n, err := console.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data = append(data, buf[:n]...)
+	}
+	if !bytes.Equal(data, []byte{'a', telnetIAC, 'b'}) {
+		t.Fatalf("read %v, expected the data without the telnet commands", data)
+	}
+	if _, err = console.Write([]byte{'x', telnetIAC, 'y'}); err != nil {
+		t.Fatal(err)
+	}
+	if err = <-done; err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,264 @@
+package utils
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"fmt"
+	"hash"
+	"strings"
+)
+
+// Crypt hashes passwords in the crypt(3) formats understood by the install
+// programs, so that the plain password does not leave the host.
+
+const cryptAlphabet = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// CRYPT_METHODS maps the crypt_method values to the crypt(3) prefixes.
+var CRYPT_METHODS = map[string]string{
+	"sha512crypt": "$6$",
+	"sha256crypt": "$5$",
+	"md5crypt":    "$1$",
+}
+
+var (
+	// the order in which the bytes of the digest are encoded
+	sha512Order = [][3]int{{0, 21, 42}, {22, 43, 1}, {44, 2, 23}, {3, 24, 45}, {25, 46, 4},
+		{47, 5, 26}, {6, 27, 48}, {28, 49, 7}, {50, 8, 29}, {9, 30, 51}, {31, 52, 10},
+		{53, 11, 32}, {12, 33, 54}, {34, 55, 13}, {56, 14, 35}, {15, 36, 57}, {37, 58, 16},
+		{59, 17, 38}, {18, 39, 60}, {40, 61, 19}, {62, 20, 41}}
+	sha256Order = [][3]int{{0, 10, 20}, {21, 1, 11}, {12, 22, 2}, {3, 13, 23}, {24, 4, 14},
+		{15, 25, 5}, {6, 16, 26}, {27, 7, 17}, {18, 28, 8}, {9, 19, 29}}
+	md5Order = [][3]int{{0, 6, 12}, {1, 7, 13}, {2, 8, 14}, {3, 9, 15}, {4, 10, 5}}
+)
+
+// CRYPT_HASH_SIZES are the lengths of the encoded hashes of the crypt(3)
+// prefixes.
+var CRYPT_HASH_SIZES = map[string]int{
+	"$6$": 86,
+	"$5$": 43,
+	"$1$": 22,
+}
+
+// IsCrypted reports whether the password is already in a crypt(3) format,
+// the prefix, the salt and the hash of the expected length. A password only
+// starting like a crypt(3) string is not.
+func IsCrypted(password string) bool {
+	for _, prefix := range CRYPT_METHODS {
+		if !strings.HasPrefix(password, prefix) {
+			continue
+		}
+		rest := password[len(prefix):]
+		saltSize := 16
+		if prefix == "$1$" {
+			saltSize = 8
+		} else if strings.HasPrefix(rest, "rounds=") {
+			i := strings.Index(rest, "$")
+			if i < 0 || !isDigits(rest[len("rounds="):i]) {
+				return false
+			}
+			rest = rest[i+1:]
+		}
+		i := strings.Index(rest, "$")
+		if i < 0 || i > saltSize || !isCryptAlphabet(rest[:i]) {
+			return false
+		}
+		hash := rest[i+1:]
+		return len(hash) == CRYPT_HASH_SIZES[prefix] && isCryptAlphabet(hash)
+	}
+	return false
+}
+
+func isDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
+
+func isCryptAlphabet(s string) bool {
+	for _, c := range s {
+		if !strings.ContainsRune(cryptAlphabet, c) {
+			return false
+		}
+	}
+	return true
+}
+
+// Crypt hashes the password with the crypt method and a random salt.
+func Crypt(method string, password string) (string, error) {
+	size := 16
+	if method == "md5crypt" {
+		size = 8
+	}
+	salt := make([]byte, size)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	for i := range salt {
+		salt[i] = cryptAlphabet[int(salt[i])%len(cryptAlphabet)]
+	}
+	return CryptWithSalt(method, password, string(salt))
+}
+
+// CryptWithSalt hashes the password with the crypt method and the salt.
+func CryptWithSalt(method string, password string, salt string) (string, error) {
+	switch method {
+	case "sha512crypt":
+		return shaCrypt(sha512.New, "$6$", sha512Order, []byte(password), salt), nil
+	case "sha256crypt":
+		return shaCrypt(sha256.New, "$5$", sha256Order, []byte(password), salt), nil
+	case "md5crypt":
+		return md5Crypt([]byte(password), salt), nil
+	}
+	return "", fmt.Errorf("Unsupported crypt method %s.", method)
+}
+
+// encode24 encodes the 24 bits of the three bytes into n characters, the
+// least significant bits first.
+func encode24(out []byte, b2 byte, b1 byte, b0 byte, n int) []byte {
+	w := uint(b2)<<16 | uint(b1)<<8 | uint(b0)
+	for ; n > 0; n-- {
+		out = append(out, cryptAlphabet[w&0x3f])
+		w >>= 6
+	}
+	return out
+}
+
+// repeat returns the digest repeated to the length.
+func repeat(digest []byte, length int) []byte {
+	out := make([]byte, 0, length)
+	for len(out) < length {
+		out = append(out, digest...)
+	}
+	return out[:length]
+}
+
+// shaCrypt implements the SHA-256 and SHA-512 crypt of Ulrich Drepper with
+// the default 5000 rounds.
+func shaCrypt(newHash func() hash.Hash, prefix string, order [][3]int, password []byte, salt string) string {
+	if len(salt) > 16 {
+		salt = salt[:16]
+	}
+	s := []byte(salt)
+	h := newHash()
+	h.Write(password)
+	h.Write(s)
+	h.Write(password)
+	b := h.Sum(nil)
+
+	h = newHash()
+	h.Write(password)
+	h.Write(s)
+	h.Write(repeat(b, len(password)))
+	for i := len(password); i > 0; i >>= 1 {
+		if i&1 != 0 {
+			h.Write(b)
+		} else {
+			h.Write(password)
+		}
+	}
+	a := h.Sum(nil)
+
+	h = newHash()
+	for range password {
+		h.Write(password)
+	}
+	p := repeat(h.Sum(nil), len(password))
+
+	h = newHash()
+	for i := 0; i < 16+int(a[0]); i++ {
+		h.Write(s)
+	}
+	ds := repeat(h.Sum(nil), len(s))
+
+	c := a
+	for i := 0; i < 5000; i++ {
+		h = newHash()
+		if i&1 != 0 {
+			h.Write(p)
+		} else {
+			h.Write(c)
+		}
+		if i%3 != 0 {
+			h.Write(ds)
+		}
+		if i%7 != 0 {
+			h.Write(p)
+		}
+		if i&1 != 0 {
+			h.Write(c)
+		} else {
+			h.Write(p)
+		}
+		c = h.Sum(nil)
+	}
+
+	out := []byte(prefix + salt + "$")
+	for _, o := range order {
+		out = encode24(out, c[o[0]], c[o[1]], c[o[2]], 4)
+	}
+	if len(c) == sha512.Size {
+		out = encode24(out, 0, 0, c[63], 2)
+	} else {
+		out = encode24(out, 0, c[31], c[30], 3)
+	}
+	return string(out)
+}
+
+// md5Crypt implements the MD5 crypt of Poul-Henning Kamp.
+func md5Crypt(password []byte, salt string) string {
+	if len(salt) > 8 {
+		salt = salt[:8]
+	}
+	s := []byte(salt)
+	h := md5.New()
+	h.Write(password)
+	h.Write(s)
+	h.Write(password)
+	alt := h.Sum(nil)
+
+	h = md5.New()
+	h.Write(password)
+	h.Write([]byte("$1$"))
+	h.Write(s)
+	h.Write(repeat(alt, len(password)))
+	for i := len(password); i > 0; i >>= 1 {
+		if i&1 != 0 {
+			h.Write([]byte{0})
+		} else {
+			h.Write(password[:1])
+		}
+	}
+	final := h.Sum(nil)
+
+	for i := 0; i < 1000; i++ {
+		h = md5.New()
+		if i&1 != 0 {
+			h.Write(password)
+		} else {
+			h.Write(final)
+		}
+		if i%3 != 0 {
+			h.Write(s)
+		}
+		if i%7 != 0 {
+			h.Write(password)
+		}
+		if i&1 != 0 {
+			h.Write(final)
+		} else {
+			h.Write(password)
+		}
+		final = h.Sum(nil)
+	}
+
+	out := []byte("$1$" + salt + "$")
+	for _, o := range md5Order {
+		out = encode24(out, final[o[0]], final[o[1]], final[o[2]], 4)
+	}
+	return string(encode24(out, 0, 0, final[11], 2))
+}
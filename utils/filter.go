@@ -0,0 +1,146 @@
+package utils
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Filter is a condition on an attribute of a resource, written as
+// key=value, key!=value, key~regex, key!~regex, 'key in (a,b)' or
+// 'key notin (a,b)'. Nested attributes are addressed with dots like
+// control_info.bmc_address.
+type Filter struct {
+	Key string
+	// Path is the key split on dots.
+	Path   []string
+	Op     string
+	Values []string
+	regex  *regexp.Regexp
+}
+
+var (
+	filterPattern = regexp.MustCompile(`^\s*([\w.\-/]+)\s*(!=|==|=|!~|~|\s+in\s*|\s+notin\s*)(.*)$`)
+	existPattern  = regexp.MustCompile(`^\s*(!?)\s*([\w.\-/]+)\s*$`)
+)
+
+// ParseFilter parses one filter. A bare key matches when the attribute
+// exists and !key when it does not.
+func ParseFilter(expr string) (*Filter, error) {
+	if m := existPattern.FindStringSubmatch(expr); m != nil {
+		return &Filter{Key: m[2], Path: strings.Split(m[2], "."), Op: m[1] + "exists"}, nil
+	}
+	m := filterPattern.FindStringSubmatch(expr)
+	if m == nil {
+		return nil, fmt.Errorf("Invalid filter '%s', expected key=value, key!=value, key~regex or 'key in (a,b)'.", expr)
+	}
+	f := &Filter{Key: m[1], Path: strings.Split(m[1], "."), Op: strings.TrimSpace(m[2])}
+	value := strings.TrimSpace(m[3])
+	switch f.Op {
+	case "==":
+		f.Op = "="
+		f.Values = []string{value}
+	case "=", "!=":
+		f.Values = []string{value}
+	case "~", "!~":
+		regex, err := regexp.Compile(value)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid regular expression in filter '%s': %s", expr, err)
+		}
+		f.regex = regex
+		f.Values = []string{value}
+	case "in", "notin":
+		if !strings.HasPrefix(value, "(") || !strings.HasSuffix(value, ")") {
+			return nil, fmt.Errorf("Invalid filter '%s', the values of %s should be in parentheses.", expr, f.Op)
+		}
+		for _, item := range strings.Split(value[1:len(value)-1], ",") {
+			f.Values = append(f.Values, strings.TrimSpace(item))
+		}
+	}
+	return f, nil
+}
+
+// ParseSelector parses comma separated filters like
+// 'rack in (r12,r13),role!=login'. Commas inside parentheses belong to the
+// values.
+func ParseSelector(expr string) ([]*Filter, error) {
+	filters := make([]*Filter, 0)
+	depth, start := 0, 0
+	for i := 0; i <= len(expr); i++ {
+		if i < len(expr) {
+			switch expr[i] {
+			case '(':
+				depth++
+			case ')':
+				depth--
+			}
+			if expr[i] != ',' || depth > 0 {
+				continue
+			}
+		}
+		f, err := ParseFilter(expr[start:i])
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, f)
+		start = i + 1
+	}
+	return filters, nil
+}
+
+// IsEqual reports whether the filter is a plain key=value which the server
+// may apply as a query parameter. Nested keys are sent with their dots, like
+// extra.rack=r12 for a label.
+func (f *Filter) IsEqual() bool {
+	return f.Op == "="
+}
+
+// Lookup returns the attribute addressed by the path.
+func Lookup(m map[string]interface{}, path []string) (interface{}, bool) {
+	var value interface{} = m
+	for _, item := range path {
+		nested, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if value, ok = nested[item]; !ok {
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+func (f *Filter) Match(m map[string]interface{}) bool {
+	raw, found := Lookup(m, f.Path)
+	value := fmt.Sprint(raw)
+	switch f.Op {
+	case "exists":
+		return found
+	case "!exists":
+		return !found
+	case "=":
+		return found && value == f.Values[0]
+	case "!=":
+		return !found || value != f.Values[0]
+	case "~":
+		return found && f.regex.MatchString(value)
+	case "!~":
+		return !found || !f.regex.MatchString(value)
+	case "in":
+		exist, _ := Contains(f.Values, value)
+		return found && exist
+	case "notin":
+		exist, _ := Contains(f.Values, value)
+		return !found || !exist
+	}
+	return false
+}
+
+func MatchAll(filters []*Filter, m map[string]interface{}) bool {
+	for _, f := range filters {
+		if !f.Match(m) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,52 @@
+package utils
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+// The secrets are encrypted with the gpg command, so that no crypto library
+// is needed and the keys of the admin are used.
+
+// GpgAvailable reports an error when the gpg command is not installed or when
+// the key of a recipient is not in the keyring.
+func GpgAvailable(recipients []string) error {
+	if _, err := exec.LookPath("gpg"); err != nil {
+		return errors.New("Could not find the gpg command, please install gnupg.")
+	}
+	for _, recipient := range recipients {
+		if err := exec.Command("gpg", "--quiet", "--list-keys", recipient).Run(); err != nil {
+			return fmt.Errorf("Could not find the gpg key of %s.", recipient)
+		}
+	}
+	return nil
+}
+
+// GpgEncrypt writes the data encrypted to the file. The data is encrypted for
+// the recipients, or with a passphrase asked by gpg when there is none.
+func GpgEncrypt(data []byte, output string, recipients []string) error {
+	args := []string{"--quiet", "--yes", "--output", output}
+	if len(recipients) > 0 {
+		args = append(args, "--encrypt")
+		for _, recipient := range recipients {
+			args = append(args, "--recipient", recipient)
+		}
+	} else {
+		args = append(args, "--symmetric", "--cipher-algo", "AES256")
+	}
+	cmd := exec.Command("gpg", args...)
+	cmd.Stdin = bytes.NewReader(data)
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+// GpgDecrypt returns the content of the encrypted file. gpg asks for the
+// passphrase when the key or the file needs one.
+func GpgDecrypt(path string) ([]byte, error) {
+	cmd := exec.Command("gpg", "--quiet", "--decrypt", path)
+	cmd.Stderr = os.Stderr
+	return cmd.Output()
+}
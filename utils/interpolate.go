@@ -0,0 +1,261 @@
+package utils
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Template is a node attribute value which may vary per node, such as
+// ip=10.0.0.{index+10}, ip=10.0.0.[11-110] or bmc_address=11.0.{rack}.{slot}.
+//
+// Expressions in braces are evaluated for each node. {index} is the position
+// of the node in the node range starting from 0, {num} is the last number in
+// the node name, {rack} and {slot} are the first and second numbers in the
+// node name (r3n12 gives 3 and 12). A variable may be followed by +, -, *, /
+// or % with an integer and a printf style format after a colon, like
+// {index+1:03d} or {index:02x}.
+//
+// A range in square brackets gives the values in order, one per node. Both
+// ends are either decimal numbers ([001-100] keeps the zero padding), MAC
+// addresses or IPv4 addresses, and the size of the range must equal the
+// number of nodes.
+//
+// Any other text in braces or brackets is kept as is, and {{ or [[ gives a
+// literal brace or bracket, so a password like p{{index} is not evaluated.
+type Template struct {
+	parts []templatePart
+}
+
+type templatePart struct {
+	literal string
+	expr    *templateExpr
+	values  []string
+}
+
+type templateExpr struct {
+	variable string
+	op       string
+	operand  int
+	format   string
+}
+
+var (
+	exprPattern  = regexp.MustCompile(`^\{\s*(index|num|rack|slot)\s*(?:([-+*/%])\s*(\d+))?\s*(?::(\d*[dxX]))?\s*\}`)
+	rangePattern = regexp.MustCompile(`^\[([0-9a-fA-F:.]+)-([0-9a-fA-F:.]+)\]`)
+	numPattern   = regexp.MustCompile(`\d+`)
+)
+
+// ParseTemplate parses the value and checks that every range in it holds
+// exactly total values.
+func ParseTemplate(value string, total int) (*Template, error) {
+	t := &Template{}
+	literal := ""
+	for i := 0; i < len(value); {
+		rest := value[i:]
+		if strings.HasPrefix(rest, "{{") || strings.HasPrefix(rest, "[[") {
+			literal += rest[:1]
+			i += 2
+			continue
+		}
+		if rest[0] == '{' {
+			if m := exprPattern.FindStringSubmatch(rest); m != nil {
+				expr := &templateExpr{variable: m[1], op: m[2], format: m[4]}
+				if m[3] != "" {
+					expr.operand, _ = strconv.Atoi(m[3])
+				}
+				if (expr.op == "/" || expr.op == "%") && expr.operand == 0 {
+					return nil, fmt.Errorf("Division by zero in %s at position %d.", value, i)
+				}
+				if expr.format == "" {
+					expr.format = "d"
+				}
+				t.parts = append(t.parts, templatePart{literal: literal}, templatePart{expr: expr})
+				literal = ""
+				i += len(m[0])
+				continue
+			}
+		}
+		if rest[0] == '[' {
+			// a bracket which is not a numeric, MAC or IPv4 range, like the
+			// [a-f] of a password, is kept as is
+			if m := rangePattern.FindStringSubmatch(rest); m != nil {
+				if values, err := expandRange(m[1], m[2]); err == nil {
+					if len(values) != total {
+						return nil, fmt.Errorf("Range %s in %s holds %d values but the node range has %d node(s).",
+							m[0], value, len(values), total)
+					}
+					t.parts = append(t.parts, templatePart{literal: literal}, templatePart{values: values})
+					literal = ""
+					i += len(m[0])
+					continue
+				}
+			}
+		}
+		literal += rest[:1]
+		i++
+	}
+	t.parts = append(t.parts, templatePart{literal: literal})
+	return t, nil
+}
+
+// Render returns the value for the node at position index of the node range.
+func (t *Template) Render(index int, name string) (string, error) {
+	var out strings.Builder
+	for _, part := range t.parts {
+		switch {
+		case part.expr != nil:
+			s, err := part.expr.eval(index, name)
+			if err != nil {
+				return "", fmt.Errorf("%s: %s", name, err)
+			}
+			out.WriteString(s)
+		case part.values != nil:
+			out.WriteString(part.values[index])
+		default:
+			out.WriteString(part.literal)
+		}
+	}
+	return out.String(), nil
+}
+
+func (expr *templateExpr) eval(index int, name string) (string, error) {
+	var v int
+	nums := numPattern.FindAllString(name, -1)
+	switch expr.variable {
+	case "index":
+		v = index
+	case "num", "rack", "slot":
+		pos := map[string]int{"num": len(nums) - 1, "rack": 0, "slot": 1}[expr.variable]
+		if pos < 0 || pos >= len(nums) {
+			return "", fmt.Errorf("could not find {%s} in the node name", expr.variable)
+		}
+		v, _ = strconv.Atoi(nums[pos])
+	}
+	switch expr.op {
+	case "+":
+		v += expr.operand
+	case "-":
+		v -= expr.operand
+	case "*":
+		v *= expr.operand
+	case "/":
+		v /= expr.operand
+	case "%":
+		v %= expr.operand
+	}
+	return fmt.Sprintf("%"+expr.format, v), nil
+}
+
+func expandRange(left string, right string) ([]string, error) {
+	if l, err := strconv.Atoi(left); err == nil {
+		r, err := strconv.Atoi(right)
+		if err != nil || r < l {
+			return nil, fmt.Errorf("Invalid range [%s-%s]", left, right)
+		}
+		format := "%d"
+		if len(left) > 1 && left[0] == '0' {
+			format = fmt.Sprintf("%%0%dd", len(left))
+		}
+		values := make([]string, 0, r-l+1)
+		for i := l; i <= r; i++ {
+			values = append(values, fmt.Sprintf(format, i))
+		}
+		return values, nil
+	}
+	if l, err := net.ParseMAC(left); err == nil && len(l) == 6 {
+		r, err := net.ParseMAC(right)
+		if err != nil || len(r) != 6 {
+			return nil, fmt.Errorf("Invalid range [%s-%s]", left, right)
+		}
+		return expandAddress(l, r, func(b []byte) string { return net.HardwareAddr(b).String() })
+	}
+	if l := net.ParseIP(left).To4(); l != nil {
+		r := net.ParseIP(right).To4()
+		if r == nil {
+			return nil, fmt.Errorf("Invalid range [%s-%s]", left, right)
+		}
+		return expandAddress(l, r, func(b []byte) string { return net.IP(b).String() })
+	}
+	return nil, fmt.Errorf("Invalid range [%s-%s]", left, right)
+}
+
+// expandAddress walks from left to right treating the addresses as big
+// endian integers.
+func expandAddress(left []byte, right []byte, format func([]byte) string) ([]string, error) {
+	var l, r uint64
+	for i := range left {
+		l = l<<8 | uint64(left[i])
+		r = r<<8 | uint64(right[i])
+	}
+	if r < l || r-l > 1<<20 {
+		return nil, fmt.Errorf("Invalid range [%s-%s]", format(left), format(right))
+	}
+	values := make([]string, 0, r-l+1)
+	for v := l; v <= r; v++ {
+		b := make([]byte, len(left))
+		for i, n := len(b)-1, v; i >= 0; i, n = i-1, n>>8 {
+			b[i] = byte(n)
+		}
+		values = append(values, format(b))
+	}
+	return values, nil
+}
+
+// Interpolator renders node attributes for each node of a node range.
+type Interpolator struct {
+	names     []string
+	templates map[string]*Template
+}
+
+func NewInterpolator(names []string) *Interpolator {
+	return &Interpolator{names: names, templates: make(map[string]*Template)}
+}
+
+func (i *Interpolator) template(value string) (*Template, error) {
+	if t, ok := i.templates[value]; ok {
+		return t, nil
+	}
+	t, err := ParseTemplate(value, len(i.names))
+	if err != nil {
+		return nil, err
+	}
+	i.templates[value] = t
+	return t, nil
+}
+
+// Render returns a copy of value with every string rendered for the node at
+// position index. Maps and slices are copied so that nodes never share them.
+func (i *Interpolator) Render(value interface{}, index int) (interface{}, error) {
+	switch v := value.(type) {
+	case string:
+		t, err := i.template(v)
+		if err != nil {
+			return nil, err
+		}
+		return t.Render(index, i.names[index])
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			rendered, err := i.Render(item, index)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s", key, err)
+			}
+			m[key] = rendered
+		}
+		return m, nil
+	case []interface{}:
+		s := make([]interface{}, 0, len(v))
+		for _, item := range v {
+			rendered, err := i.Render(item, index)
+			if err != nil {
+				return nil, err
+			}
+			s = append(s, rendered)
+		}
+		return s, nil
+	}
+	return value, nil
+}
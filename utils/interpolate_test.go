@@ -0,0 +1,63 @@
+package utils
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTemplateRender(t *testing.T) {
+	names := []string{"r1n01", "r1n02", "r2n01"}
+	cases := []struct {
+		value    string
+		expected []string
+	}{
+		{"10.0.0.{index+10}", []string{"10.0.0.10", "10.0.0.11", "10.0.0.12"}},
+		{"11.0.{rack}.{slot}", []string{"11.0.1.1", "11.0.1.2", "11.0.2.1"}},
+		{"n{num:03d}", []string{"n001", "n002", "n001"}},
+		{"10.0.0.[08-10]", []string{"10.0.0.08", "10.0.0.09", "10.0.0.10"}},
+		{"[10.0.0.254-10.0.1.0]", []string{"10.0.0.254", "10.0.0.255", "10.0.1.0"}},
+		{"[00:00:00:00:00:ff-00:00:00:00:01:01]", []string{"00:00:00:00:00:ff", "00:00:00:00:01:00", "00:00:00:00:01:01"}},
+		// other text in braces or brackets is kept as is
+		{"pass[a-f]", []string{"pass[a-f]", "pass[a-f]", "pass[a-f]"}},
+		{"x[3-1]", []string{"x[3-1]", "x[3-1]", "x[3-1]"}},
+		{"pw{name}", []string{"pw{name}", "pw{name}", "pw{name}"}},
+		{"a{b", []string{"a{b", "a{b", "a{b"}},
+		{"p{{index}", []string{"p{index}", "p{index}", "p{index}"}},
+		{"[[1-3]", []string{"[1-3]", "[1-3]", "[1-3]"}},
+	}
+	for _, c := range cases {
+		tmpl, err := ParseTemplate(c.value, len(names))
+		if err != nil {
+			t.Errorf("%s: %s", c.value, err)
+			continue
+		}
+		got := make([]string, 0, len(names))
+		for i, name := range names {
+			s, err := tmpl.Render(i, name)
+			if err != nil {
+				t.Fatalf("%s: %s", c.value, err)
+			}
+			got = append(got, s)
+		}
+		if !reflect.DeepEqual(got, c.expected) {
+			t.Errorf("%s: got %v, expected %v", c.value, got, c.expected)
+		}
+	}
+}
+
+func TestTemplateErrors(t *testing.T) {
+	cases := []struct {
+		value string
+		err   string
+	}{
+		{"10.0.0.[1-4]", "holds 4 values"},
+		{"[00:00:00:00:00:01-00:00:00:00:00:02]", "holds 2 values"},
+		{"{index/0}", "Division by zero"},
+	}
+	for _, c := range cases {
+		if _, err := ParseTemplate(c.value, 3); err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Errorf("%s: got %v, expected an error with %q", c.value, err, c.err)
+		}
+	}
+}
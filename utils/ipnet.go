@@ -0,0 +1,108 @@
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// ParseNetwork returns the network of a subnet in CIDR notation like
+// 10.0.0.0/22 or fd00::/64, or of a subnet with its netmask, which is dotted
+// like 255.255.252.0 or a prefix length like /64. The subnet must be the
+// address of the network.
+func ParseNetwork(subnet string, netmask string) (*net.IPNet, error) {
+	cidr := subnet
+	if !strings.Contains(subnet, "/") {
+		if netmask == "" {
+			return nil, fmt.Errorf("Missing the netmask of subnet %s.", subnet)
+		}
+		prefix := strings.TrimPrefix(netmask, "/")
+		if mask := net.ParseIP(netmask).To4(); mask != nil {
+			ones, bits := net.IPMask(mask).Size()
+			if bits == 0 {
+				return nil, fmt.Errorf("Invalid netmask %s.", netmask)
+			}
+			prefix = strconv.Itoa(ones)
+		}
+		cidr = subnet + "/" + prefix
+	}
+	ip, ipnet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid subnet %s.", cidr)
+	}
+	if !ip.Equal(ipnet.IP) {
+		ones, _ := ipnet.Mask.Size()
+		return nil, fmt.Errorf("%s is not the address of the network, did you mean %s/%d?", cidr, ipnet.IP, ones)
+	}
+	return ipnet, nil
+}
+
+// Netmask returns the netmask of the network as stored by the server, dotted
+// for IPv4 and as /<prefix length> for IPv6.
+func Netmask(ipnet *net.IPNet) string {
+	if len(ipnet.Mask) == net.IPv4len {
+		return net.IP(ipnet.Mask).String()
+	}
+	ones, _ := ipnet.Mask.Size()
+	return "/" + strconv.Itoa(ones)
+}
+
+// NetworksOverlap reports whether the networks share an address.
+func NetworksOverlap(a *net.IPNet, b *net.IPNet) bool {
+	return a.Contains(b.IP) || b.Contains(a.IP)
+}
+
+// CompareIP compares the addresses as big endian integers.
+func CompareIP(a net.IP, b net.IP) int {
+	return bytes.Compare(a.To16(), b.To16())
+}
+
+// ParseIPRange parses a range of addresses like 10.0.0.100-10.0.0.200.
+func ParseIPRange(r string) (net.IP, net.IP, error) {
+	items := strings.Split(r, "-")
+	if len(items) != 2 {
+		return nil, nil, fmt.Errorf("Invalid range %s, expected <first ip>-<last ip>.", r)
+	}
+	first := net.ParseIP(strings.TrimSpace(items[0]))
+	last := net.ParseIP(strings.TrimSpace(items[1]))
+	if first == nil || last == nil || (first.To4() == nil) != (last.To4() == nil) || CompareIP(first, last) > 0 {
+		return nil, nil, fmt.Errorf("Invalid range %s, expected <first ip>-<last ip>.", r)
+	}
+	return first, last, nil
+}
+
+// BroadcastIP returns the last address of the network.
+func BroadcastIP(ipnet *net.IPNet) net.IP {
+	ip := make(net.IP, len(ipnet.IP))
+	for i := range ip {
+		ip[i] = ipnet.IP[i] | ^ipnet.Mask[i]
+	}
+	return ip
+}
+
+// NextIP returns the address following the ip.
+func NextIP(ip net.IP) net.IP {
+	next := make(net.IP, len(ip))
+	copy(next, ip)
+	for i := len(next) - 1; i >= 0; i-- {
+		if next[i]++; next[i] != 0 {
+			break
+		}
+	}
+	return next
+}
+
+// RangeSize returns the number of addresses from first to last.
+func RangeSize(first net.IP, last net.IP) *big.Int {
+	size := new(big.Int).Sub(new(big.Int).SetBytes(last.To16()), new(big.Int).SetBytes(first.To16()))
+	return size.Add(size, big.NewInt(1))
+}
+
+// NetworkSize returns the number of addresses of the network.
+func NetworkSize(ipnet *net.IPNet) *big.Int {
+	ones, bits := ipnet.Mask.Size()
+	return new(big.Int).Lsh(big.NewInt(1), uint(bits-ones))
+}
@@ -0,0 +1,159 @@
+package utils
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// ISO reads files from an ISO 9660 image. Names are taken from the Rock
+// Ridge NM entries when present, otherwise the ISO 9660 names are lowered
+// and their version suffix removed.
+type ISO struct {
+	file *os.File
+	root isoRecord
+}
+
+type isoRecord struct {
+	name   string
+	extent int64
+	size   int64
+	dir    bool
+}
+
+const isoSector = 2048
+
+func OpenISO(path string) (*ISO, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	// the volume descriptors start at sector 16
+	for sector := int64(16); sector < 64; sector++ {
+		desc := make([]byte, isoSector)
+		if _, err = file.ReadAt(desc, sector*isoSector); err != nil {
+			break
+		}
+		if string(desc[1:6]) != "CD001" {
+			break
+		}
+		if desc[0] == 1 {
+			root, _, err := parseISORecord(desc[156:190])
+			if err != nil {
+				break
+			}
+			return &ISO{file: file, root: root}, nil
+		}
+		if desc[0] == 255 {
+			break
+		}
+	}
+	file.Close()
+	return nil, fmt.Errorf("%s is not an ISO 9660 image.", path)
+}
+
+func (iso *ISO) Close() error {
+	return iso.file.Close()
+}
+
+// parseISORecord parses a directory record and returns it with its length.
+// A record shorter than its name, or than the data, is an error, as the
+// image may be truncated or forged.
+func parseISORecord(data []byte) (isoRecord, int, error) {
+	length := int(data[0])
+	if length < 34 || length > len(data) {
+		return isoRecord{}, length, errors.New("Invalid directory record in the ISO image.")
+	}
+	record := isoRecord{
+		extent: int64(binary.LittleEndian.Uint32(data[2:6])),
+		size:   int64(binary.LittleEndian.Uint32(data[10:14])),
+		dir:    data[25]&2 != 0,
+	}
+	nameLen := int(data[32])
+	if 33+nameLen > length {
+		return isoRecord{}, length, errors.New("Invalid directory record in the ISO image.")
+	}
+	name := string(data[33 : 33+nameLen])
+	// the system use area follows the name, padded to an even offset
+	use := 33 + nameLen
+	if nameLen%2 == 0 {
+		use++
+	}
+	for use+4 <= length {
+		sig, size := string(data[use:use+2]), int(data[use+2])
+		if size < 4 || use+size > length {
+			break
+		}
+		if sig == "NM" && size > 5 {
+			record.name = string(data[use+5 : use+size])
+		}
+		use += size
+	}
+	if record.name == "" {
+		if i := strings.Index(name, ";"); i >= 0 {
+			name = name[:i]
+		}
+		record.name = strings.ToLower(strings.TrimSuffix(name, "."))
+	}
+	return record, length, nil
+}
+
+func (iso *ISO) readDir(dir isoRecord) ([]isoRecord, error) {
+	data := make([]byte, dir.size)
+	if _, err := iso.file.ReadAt(data, dir.extent*isoSector); err != nil {
+		return nil, err
+	}
+	records := make([]isoRecord, 0)
+	for offset := 0; offset < len(data); {
+		if data[offset] == 0 {
+			// records do not cross sectors, skip the padding
+			offset = (offset/isoSector + 1) * isoSector
+			continue
+		}
+		record, length, err := parseISORecord(data[offset:])
+		if err != nil {
+			return nil, err
+		}
+		// skip the records of the directory itself and its parent
+		if nameLen := data[offset+32]; nameLen != 1 || data[offset+33] > 1 {
+			records = append(records, record)
+		}
+		offset += length
+	}
+	return records, nil
+}
+
+// ReadFile returns the content of the file at the slash separated path.
+func (iso *ISO) ReadFile(path string) ([]byte, error) {
+	current := iso.root
+	for _, item := range strings.Split(strings.Trim(path, "/"), "/") {
+		if !current.dir {
+			return nil, os.ErrNotExist
+		}
+		records, err := iso.readDir(current)
+		if err != nil {
+			return nil, err
+		}
+		found := false
+		for _, record := range records {
+			if record.name == item {
+				current, found = record, true
+				break
+			}
+		}
+		if !found {
+			return nil, os.ErrNotExist
+		}
+	}
+	if current.dir {
+		return nil, errors.New(path + " is a directory.")
+	}
+	data := make([]byte, current.size)
+	if _, err := iso.file.ReadAt(data, current.extent*isoSector); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return data, nil
+}
@@ -0,0 +1,260 @@
+package utils
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// KeyValue is one key=value pair given on the command line.
+//
+// A key is split on '.' into a path for nested maps, so
+// control.bmc_username=root gives {"control": {"bmc_username": "root"}}, and
+// may end with a type like vlan:int=10. The types are str, int, float and
+// bool. Without a type the value is a string, so password=true stays one and
+// a boolean needs primary:bool=true. Quotes and backslash escapes keep the
+// special characters, like password='a,b=c' or name=a\.b.
+type KeyValue struct {
+	Path  []string
+	Value interface{}
+	Pos   int
+}
+
+type ParseError struct {
+	Input string
+	Pos   int
+	Msg   string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%s at position %d of '%s'", e.Msg, e.Pos+1, e.Input)
+}
+
+type kvScanner struct {
+	input string
+	pos   int
+	// list is true when pairs are separated by commas.
+	list bool
+}
+
+func (s *kvScanner) errorf(pos int, format string, args ...interface{}) error {
+	return &ParseError{Input: s.input, Pos: pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+// token reads until one of the stop characters, handling quotes and escapes.
+func (s *kvScanner) token(stop string) (string, error) {
+	var out strings.Builder
+	for s.pos < len(s.input) {
+		c := s.input[s.pos]
+		switch {
+		case strings.IndexByte(stop, c) >= 0:
+			return out.String(), nil
+		case c == '\\':
+			if s.pos+1 >= len(s.input) {
+				return "", s.errorf(s.pos, "Dangling escape")
+			}
+			out.WriteByte(s.input[s.pos+1])
+			s.pos += 2
+		case c == '\'' || c == '"':
+			start := s.pos
+			s.pos++
+			for {
+				if s.pos >= len(s.input) {
+					return "", s.errorf(start, "Unterminated quote")
+				}
+				if s.input[s.pos] == c {
+					s.pos++
+					break
+				}
+				if s.input[s.pos] == '\\' && c == '"' && s.pos+1 < len(s.input) {
+					s.pos++
+				}
+				out.WriteByte(s.input[s.pos])
+				s.pos++
+			}
+		default:
+			out.WriteByte(c)
+			s.pos++
+		}
+	}
+	return out.String(), nil
+}
+
+func (s *kvScanner) pair() (*KeyValue, error) {
+	kv := &KeyValue{Pos: s.pos}
+	for {
+		start := s.pos
+		segment, err := s.token(".:=,")
+		if err != nil {
+			return nil, err
+		}
+		if segment == "" {
+			return nil, s.errorf(start, "Empty key")
+		}
+		kv.Path = append(kv.Path, segment)
+		if s.pos < len(s.input) && s.input[s.pos] == '.' {
+			s.pos++
+			continue
+		}
+		break
+	}
+	var kind string
+	if s.pos < len(s.input) && s.input[s.pos] == ':' {
+		s.pos++
+		start := s.pos
+		var err error
+		kind, err = s.token("=,")
+		if err != nil {
+			return nil, err
+		}
+		if kind != "str" && kind != "int" && kind != "float" && kind != "bool" {
+			return nil, s.errorf(start, "Unknown type '%s', expected str, int, float or bool", kind)
+		}
+	}
+	if s.pos >= len(s.input) || s.input[s.pos] != '=' {
+		return nil, s.errorf(s.pos, "Expected '=' after key '%s'", strings.Join(kv.Path, "."))
+	}
+	s.pos++
+	start := s.pos
+	stop := ""
+	if s.list {
+		stop = ","
+	}
+	raw, err := s.token(stop)
+	if err != nil {
+		return nil, err
+	}
+	switch kind {
+	case "str":
+		kv.Value = raw
+	case "int":
+		kv.Value, err = strconv.ParseInt(raw, 10, 64)
+	case "float":
+		kv.Value, err = strconv.ParseFloat(raw, 64)
+	case "bool":
+		kv.Value, err = strconv.ParseBool(raw)
+	default:
+		kv.Value = raw
+	}
+	if err != nil {
+		return nil, s.errorf(start, "Invalid %s value '%s'", kind, raw)
+	}
+	return kv, nil
+}
+
+// ParseKeyValue parses a single key=value argument. Commas in the value are
+// kept as they are.
+func ParseKeyValue(input string) (*KeyValue, error) {
+	s := &kvScanner{input: input}
+	return s.pair()
+}
+
+// SplitArgs splits a line typed by the user into key=value arguments like a
+// shell, on the spaces outside of quotes and escapes. The quotes and the
+// escapes are kept for ParseKeyValue, so password='a b' gives one argument.
+func SplitArgs(input string) ([]string, error) {
+	s := &kvScanner{input: input}
+	args := make([]string, 0)
+	for {
+		for s.pos < len(s.input) && (s.input[s.pos] == ' ' || s.input[s.pos] == '\t') {
+			s.pos++
+		}
+		if s.pos >= len(s.input) {
+			return args, nil
+		}
+		start := s.pos
+		if _, err := s.token(" \t"); err != nil {
+			return nil, err
+		}
+		args = append(args, s.input[start:s.pos])
+	}
+}
+
+// ParseKeyValueList parses comma separated pairs like
+// bmc_address=11.0.0.0,bmc_password=password,bmc_username=admin
+func ParseKeyValueList(input string) ([]*KeyValue, error) {
+	s := &kvScanner{input: input, list: true}
+	var pairs []*KeyValue
+	for {
+		kv, err := s.pair()
+		if err != nil {
+			return nil, err
+		}
+		pairs = append(pairs, kv)
+		if s.pos >= len(s.input) {
+			return pairs, nil
+		}
+		// skip the comma
+		s.pos++
+	}
+}
+
+// SetPath stores the value in m, creating the nested maps of the path.
+func SetPath(m map[string]interface{}, path []string, value interface{}) error {
+	for i, key := range path[:len(path)-1] {
+		next, ok := m[key]
+		if !ok {
+			next = make(map[string]interface{})
+			m[key] = next
+		}
+		nested, ok := next.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s is not a map", strings.Join(path[:i+1], "."))
+		}
+		m = nested
+	}
+	m[path[len(path)-1]] = value
+	return nil
+}
+
+func pairsToMap(m map[string]interface{}, pairs []*KeyValue, input string) error {
+	for _, kv := range pairs {
+		if err := SetPath(m, kv.Path, kv.Value); err != nil {
+			return &ParseError{Input: input, Pos: kv.Pos, Msg: err.Error()}
+		}
+	}
+	return nil
+}
+
+func KeyValueToMap(value string) (map[string]interface{}, error) {
+	// transform the string like
+	// bmc_address=11.0.0.0,bmc_password=password,bmc_username=admin
+	pairs, err := ParseKeyValueList(value)
+	if err != nil {
+		return nil, err
+	}
+	m := make(map[string]interface{})
+	if err = pairsToMap(m, pairs, value); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func KeyValueArrayToMapArray(values []string) ([]interface{}, error) {
+	// transform the string array like
+	// -i mac=42:87:0a:05:00:00,primary=True,name=eth0 -i mac=42:87:0a:05:00:00,name=eth1`
+	var m_array []interface{}
+	for _, value := range values {
+		m, err := KeyValueToMap(value)
+		if err != nil {
+			return nil, err
+		}
+		m_array = append(m_array, m)
+	}
+	return m_array, nil
+}
+
+func KeyValueArrayToMap(values []string) (map[string]interface{}, error) {
+	// transform the arguments like arch=x86_64 control.bmc_username=admin
+	m := make(map[string]interface{})
+	for _, value := range values {
+		kv, err := ParseKeyValue(value)
+		if err != nil {
+			return nil, err
+		}
+		if err = pairsToMap(m, []*KeyValue{kv}, value); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
@@ -0,0 +1,218 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// SecretStore holds the secrets referenced by the node attributes as
+// secret://<name>. It is a json file encrypted with gpg, for the recipients
+// it records or with a passphrase when there is none.
+type SecretStore struct {
+	path       string
+	Recipients []string          `json:"recipients"`
+	Secrets    map[string]string `json:"secrets"`
+}
+
+const SECRET_SCHEME = "secret://"
+
+var (
+	// SECRET_KEYS are the attributes holding secrets, which are masked when
+	// printed.
+	SECRET_KEYS = []string{"bmc_password", "password"}
+	SECRET_MASK = "********"
+)
+
+// SecretStorePath returns the path of the store, from XCAT3_SECRETS or else
+// ~/.xcat3/secrets.json.gpg.
+func SecretStorePath() string {
+	if path := os.Getenv("XCAT3_SECRETS"); path != "" {
+		return path
+	}
+	return filepath.Join(os.Getenv("HOME"), ".xcat3", "secrets.json.gpg")
+}
+
+// OpenSecretStore decrypts the store, or returns an empty one when the file
+// does not exist yet.
+func OpenSecretStore() (*SecretStore, error) {
+	store := &SecretStore{path: SecretStorePath(), Secrets: make(map[string]string)}
+	if _, err := os.Stat(store.path); os.IsNotExist(err) {
+		return store, nil
+	}
+	if err := GpgAvailable(nil); err != nil {
+		return nil, err
+	}
+	content, err := GpgDecrypt(store.path)
+	if err != nil {
+		return nil, fmt.Errorf("Could not decrypt the secret store %s: %s", store.path, err)
+	}
+	if err = json.Unmarshal(content, store); err != nil {
+		return nil, fmt.Errorf("Could not read the secret store %s: %s", store.path, err)
+	}
+	if store.Secrets == nil {
+		store.Secrets = make(map[string]string)
+	}
+	return store, nil
+}
+
+// Save encrypts the store into a temporary file first, so that a failure
+// does not lose the previous secrets.
+func (store *SecretStore) Save() error {
+	if err := GpgAvailable(store.Recipients); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(store.path), 0700); err != nil {
+		return err
+	}
+	content, err := json.Marshal(store)
+	if err != nil {
+		return err
+	}
+	tmp := store.path + ".tmp"
+	if err = GpgEncrypt(content, tmp, store.Recipients); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, store.path)
+}
+
+func (store *SecretStore) Path() string {
+	return store.path
+}
+
+func (store *SecretStore) Names() []string {
+	names := make([]string, 0, len(store.Secrets))
+	for name := range store.Secrets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Reference returns the secret://<name> of the value, or false when no
+// secret has this value.
+func (store *SecretStore) Reference(value string) (string, bool) {
+	for _, name := range store.Names() {
+		if store.Secrets[name] == value {
+			return SECRET_SCHEME + name, true
+		}
+	}
+	return "", false
+}
+
+// IsSecretKey reports whether the attribute holds a secret.
+func IsSecretKey(key string) bool {
+	exist, _ := Contains(SECRET_KEYS, key)
+	return exist
+}
+
+// HasSecretRefs reports whether a string in the data is a secret reference.
+func HasSecretRefs(data interface{}) bool {
+	switch v := data.(type) {
+	case string:
+		return strings.HasPrefix(v, SECRET_SCHEME)
+	case map[string]interface{}:
+		for _, value := range v {
+			if HasSecretRefs(value) {
+				return true
+			}
+		}
+	case []interface{}:
+		for _, value := range v {
+			if HasSecretRefs(value) {
+				return true
+			}
+		}
+	case []map[string]interface{}:
+		for _, value := range v {
+			if HasSecretRefs(value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ResolveSecrets replaces the secret references in the maps and the slices
+// of the data with the secrets. The store is only decrypted when the data
+// holds a reference.
+func ResolveSecrets(data interface{}) error {
+	if !HasSecretRefs(data) {
+		return nil
+	}
+	store, err := OpenSecretStore()
+	if err != nil {
+		return err
+	}
+	return store.resolve(data)
+}
+
+func (store *SecretStore) lookup(value interface{}) (interface{}, error) {
+	ref, ok := value.(string)
+	if !ok || !strings.HasPrefix(ref, SECRET_SCHEME) {
+		return value, store.resolve(value)
+	}
+	secret, ok := store.Secrets[strings.TrimPrefix(ref, SECRET_SCHEME)]
+	if !ok {
+		return nil, fmt.Errorf("Could not find %s in the secret store %s, see xcat3 secret set.", ref, store.path)
+	}
+	return secret, nil
+}
+
+func (store *SecretStore) resolve(data interface{}) (err error) {
+	switch v := data.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			if v[key], err = store.lookup(value); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for i, value := range v {
+			if v[i], err = store.lookup(value); err != nil {
+				return err
+			}
+		}
+	case []map[string]interface{}:
+		for _, value := range v {
+			if err = store.resolve(value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// MaskSecrets replaces the values of the SECRET_KEYS in the data with
+// SECRET_MASK, except the secret references.
+func MaskSecrets(data interface{}) {
+	switch v := data.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			if s, ok := value.(string); ok && IsSecretKey(key) && s != "" && !strings.HasPrefix(s, SECRET_SCHEME) {
+				v[key] = SECRET_MASK
+			} else {
+				MaskSecrets(value)
+			}
+		}
+	case []interface{}:
+		for _, value := range v {
+			MaskSecrets(value)
+		}
+	}
+}
+
+// MaskSecretsJson hides the secrets in the data in json format, like the
+// passwords of the nodes or of the passwd entries.
+func MaskSecretsJson(result []byte) ([]byte, error) {
+	var data interface{}
+	if err := json.Unmarshal(result, &data); err != nil {
+		return nil, err
+	}
+	MaskSecrets(data)
+	return json.Marshal(data)
+}
@@ -0,0 +1,94 @@
+package utils
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+)
+
+// The terminal is driven with stty so that no terminal library is needed.
+
+func stty(args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = os.Stdin
+	out, err := cmd.Output()
+	return strings.TrimSpace(string(out)), err
+}
+
+// IsTerminal reports whether the file is a terminal.
+func IsTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// MakeRaw puts the terminal of stdin into raw mode, without echo, and returns
+// the function restoring the previous mode.
+func MakeRaw() (func(), error) {
+	saved, err := stty("-g")
+	if err != nil {
+		return nil, err
+	}
+	if _, err = stty("raw", "-echo"); err != nil {
+		return nil, err
+	}
+	return func() {
+		stty(saved)
+	}, nil
+}
+
+// ReadPassword prints the prompt and reads a line from the terminal of stdin
+// without echo.
+func ReadPassword(prompt string) (string, error) {
+	saved, err := stty("-g")
+	if !IsTerminal(os.Stdin) || err != nil {
+		return "", fmt.Errorf("Could not prompt for the password, stdin is not a terminal.")
+	}
+	if _, err = stty("-echo"); err != nil {
+		return "", err
+	}
+	defer stty(saved)
+	// restore the echo when interrupted at the prompt
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	defer func() {
+		signal.Stop(interrupt)
+		close(interrupt)
+	}()
+	go func() {
+		if _, ok := <-interrupt; ok {
+			stty(saved)
+			fmt.Fprintln(os.Stderr)
+			os.Exit(130)
+		}
+	}()
+	fmt.Fprint(os.Stderr, prompt)
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// TerminalSize returns the number of rows and columns of the terminal of
+// stdin, or 24x80 when it is unknown.
+func TerminalSize() (int, int) {
+	out, err := stty("size")
+	if err != nil {
+		return 24, 80
+	}
+	items := strings.Fields(out)
+	if len(items) != 2 {
+		return 24, 80
+	}
+	rows, err1 := strconv.Atoi(items[0])
+	cols, err2 := strconv.Atoi(items[1])
+	if err1 != nil || err2 != nil || rows <= 0 || cols <= 0 {
+		return 24, 80
+	}
+	return rows, cols
+}
@@ -13,57 +13,6 @@ import (
 	"strings"
 )
 
-func KeyValueArrayToMapArray(values []string) ([]interface{}, error) {
-	// transform the string array like
-	// -i mac=42:87:0a:05:00:00,primary=True,name=eth0 -i mac=42:87:0a:05:00:00,name=eth1`
-	var m_array []interface{}
-	for _, value := range values {
-		m, err := KeyValueToMap(value, ",")
-		if err != nil {
-			return nil, err
-		}
-		m_array = append(m_array, m)
-	}
-	return m_array, nil
-}
-
-func KeyValueToMap(value string, sep string) (map[string]interface{}, error) {
-	// transform the string like
-	// bmc_address=11.0.0.0,bmc_password=password,bmc_username=admin
-	m := make(map[string]interface{})
-	temps := strings.Split(value, sep)
-	for _, temp := range temps {
-		item := strings.Split(temp, "=")
-		if len(temp) < 2 {
-			return nil, fmt.Errorf("The format of %s is not correct.", value)
-		}
-		b, err := strconv.ParseBool(item[1])
-		if err == nil {
-			m[item[0]] = b
-		} else {
-			m[item[0]] = item[1]
-		}
-	}
-	return m, nil
-}
-
-func KeyValueArrayToMap(values []string, sep string) (map[string]interface{}, error) {
-	m := make(map[string]interface{})
-	for _, value := range values {
-		item := strings.Split(value, sep)
-		if len(item) < 2 {
-			return nil, fmt.Errorf("The format of %s is not correct.", item)
-		}
-		b, err := strconv.ParseBool(item[1])
-		if err == nil {
-			m[item[0]] = b
-		} else {
-			m[item[0]] = item[1]
-		}
-	}
-	return m, nil
-}
-
 func ToNodeArray(value string) (names []string, err error) {
 	items := strings.Split(value, ",")
 	for _, item := range items {
@@ -81,8 +30,13 @@ func ToNodeArray(value string) (names []string, err error) {
 			if err != nil {
 				return nil, fmt.Errorf("Invalid node format %s.", item)
 			}
+			// node[01-40] keeps the zero padding
+			format := "%s%d"
+			if len(num_parts[0]) > 1 && num_parts[0][0] == '0' {
+				format = fmt.Sprintf("%%s%%0%dd", len(num_parts[0]))
+			}
 			for left <= right {
-				name := fmt.Sprintf("%s%d", prefix, left)
+				name := fmt.Sprintf(format, prefix, left)
 				left += 1
 				names = append(names, name)
 			}
@@ -98,7 +52,7 @@ func ToNodeArray(value string) (names []string, err error) {
 }
 
 func RmDuplicate(strs []string) (ret []string) {
-	sort.Strings(strs)
+	SortNatural(strs)
 	for i := 0; i < len(strs); i++ {
 		if i > 0 && strs[i-1] == strs[i] {
 			continue
@@ -108,12 +62,64 @@ func RmDuplicate(strs []string) (ret []string) {
 	return ret
 }
 
+// NaturalLess compares strings so that embedded numbers are ordered by value,
+// which puts node2 before node10.
+func NaturalLess(a string, b string) bool {
+	for a != "" && b != "" {
+		i, j := digitPrefix(a), digitPrefix(b)
+		if i > 0 && j > 0 {
+			x, y := strings.TrimLeft(a[:i], "0"), strings.TrimLeft(b[:j], "0")
+			if len(x) != len(y) {
+				return len(x) < len(y)
+			}
+			if x != y {
+				return x < y
+			}
+			if i != j {
+				return i < j
+			}
+			a, b = a[i:], b[j:]
+			continue
+		}
+		if a[0] != b[0] {
+			return a[0] < b[0]
+		}
+		a, b = a[1:], b[1:]
+	}
+	return len(a) < len(b)
+}
+
+func digitPrefix(s string) int {
+	i := 0
+	for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+		i++
+	}
+	return i
+}
+
+func SortNatural(strs []string) {
+	sort.SliceStable(strs, func(i, j int) bool { return NaturalLess(strs[i], strs[j]) })
+}
+
 func MergeMap(a map[string]interface{}, b map[string]interface{}) {
 	for k, v := range b {
 		a[k] = v
 	}
 }
 
+// DeepMergeMap merges b into a, descending into the maps both of them hold.
+func DeepMergeMap(a map[string]interface{}, b map[string]interface{}) {
+	for k, v := range b {
+		src, ok := v.(map[string]interface{})
+		dst, ok2 := a[k].(map[string]interface{})
+		if ok && ok2 {
+			DeepMergeMap(dst, src)
+			continue
+		}
+		a[k] = v
+	}
+}
+
 func MergeSlice(a []interface{}, b []interface{}) {
 	for _, value := range b {
 		a = append(a, value)